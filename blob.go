@@ -0,0 +1,212 @@
+////////////////////////////////////////////////////////////////
+
+package abb
+
+////////////////////////////////////////////////////////////////
+
+import(
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////
+
+// position blobs of version 2 are made of lines, the version line, the fen,
+// the engine depth and one line per move of comma separated values
+// algeb,score,eval,minimaxdepth,haspv[,w/d/l[,pv]], empty trailing values
+// are omitted, new values are appended and ignored by older readers, blobs
+// without version line are version 1 blobs of the form
+// fen;;enginedepth;;algeb;score;eval;minimaxdepth;haspv[;w,d,l[;pv]]|...
+
+const BLOB_VERSION = "v2"
+
+////////////////////////////////////////////////////////////////
+
+// Serialize returns the comma separated values of the move,
+// without the empty trailing wdl and pv
+func (m BookMove) Serialize() string{
+	values := []string{
+		m.Algeb,
+		strconv.Itoa(m.Score),
+		strconv.Itoa(m.Eval),
+		strconv.Itoa(m.Minimaxdepth),
+		strconv.Itoa(m.Haspv),
+	}
+	if ( m.Wdl != [3]int{} ) || ( len(m.Pv) > 0 ){
+		values = append(values, fmt.Sprintf("%d/%d/%d", m.Wdl[0], m.Wdl[1], m.Wdl[2]))
+	}
+	if len(m.Pv) > 0{
+		values = append(values, strings.Join(m.Pv, " "))
+	}
+	return strings.Join(values, ",")
+}
+
+// BookMoveFromValues parses the comma separated values of a move
+// of a version 2 blob
+func BookMoveFromValues(values string) (BookMove, error){
+	parts := strings.Split(values, ",")
+	if len(parts) < 5{
+		return BookMove{}, fmt.Errorf("move %q has %d values, want at least 5", values, len(parts))
+	}
+	m := BookMove{Algeb: parts[0]}
+	if m.Algeb == ""{
+		return m, fmt.Errorf("move %q has no algeb", values)
+	}
+	ints := []*int{&m.Score, &m.Eval, &m.Minimaxdepth, &m.Haspv}
+	for i, ptr := range(ints){
+		var err error
+		*ptr, err = strconv.Atoi(parts[i+1])
+		if err != nil{
+			return m, fmt.Errorf("move %q : %v", values, err)
+		}
+	}
+	if len(parts) > 5{
+		var err error
+		m.Wdl, err = parsewdl(parts[5], "/")
+		if err != nil{
+			return m, fmt.Errorf("move %q : %v", values, err)
+		}
+	}
+	if len(parts) > 6{
+		m.Pv = strings.Fields(parts[6])
+	}
+	return m, nil
+}
+
+func parsewdl(value string, sep string) ([3]int, error){
+	wdl := [3]int{}
+	parts := strings.Split(value, sep)
+	if len(parts) != len(wdl){
+		return wdl, fmt.Errorf("invalid wdl %q", value)
+	}
+	for i, part := range(parts){
+		var err error
+		wdl[i], err = strconv.Atoi(part)
+		if err != nil{
+			return wdl, fmt.Errorf("invalid wdl %q", value)
+		}
+	}
+	return wdl, nil
+}
+
+// BookMoveFromBlob parses a move of a version 1 blob
+func BookMoveFromBlob(blob string) (BookMove, error){
+	parts := strings.Split(blob, ";")
+	if len(parts) < 5{
+		return BookMove{}, fmt.Errorf("move blob %q has %d parts, want at least 5", blob, len(parts))
+	}
+	m := BookMove{Algeb: parts[0]}
+	ints := []*int{&m.Score, &m.Eval, &m.Minimaxdepth, &m.Haspv}
+	for i, ptr := range(ints){
+		var err error
+		*ptr, err = strconv.Atoi(parts[i+1])
+		if err != nil{
+			return m, fmt.Errorf("move blob %q : %v", blob, err)
+		}
+	}
+	if len(parts) > 5{
+		var err error
+		m.Wdl, err = parsewdl(parts[5], ",")
+		if err != nil{
+			return m, fmt.Errorf("move blob %q : %v", blob, err)
+		}
+	}
+	if len(parts) > 6{
+		m.Pv = strings.Fields(parts[6])
+	}
+	return m, nil
+}
+
+////////////////////////////////////////////////////////////////
+
+// Serialize returns the position as a version 2 blob
+func (p BookPosition) Serialize() map[string]interface{}{
+	lines := []string{
+		BLOB_VERSION,
+		p.Fen,
+		strconv.Itoa(p.Enginedepth),
+	}
+	for _, m := range(p.Moves){
+		lines = append(lines, m.Serialize())
+	}
+	return map[string]interface{}{
+		"blob": strings.Join(lines, "\n"),
+	}
+}
+
+// blobversion returns the version of a blob starting with a v<N> line,
+// 1 for blobs without version line
+func blobversion(blob string) int{
+	line, _, found := strings.Cut(blob, "\n")
+	if !found || !strings.HasPrefix(line, "v"){
+		return 1
+	}
+	version, err := strconv.Atoi(line[1:])
+	if ( err != nil ) || ( version < 1 ){
+		return 1
+	}
+	return version
+}
+
+// BookPositionFromBlob parses a position blob of version 1 or 2
+func BookPositionFromBlob(blob string) (BookPosition, error){
+	switch version := blobversion(blob); version{
+	case 1:
+		return bookpositionfromv1blob(blob)
+	case 2:
+		return bookpositionfromv2blob(blob)
+	default:
+		return BookPosition{}, fmt.Errorf("unsupported blob version v%d", version)
+	}
+}
+
+func bookpositionfromv2blob(blob string) (BookPosition, error){
+	lines := strings.Split(blob, "\n")
+	if len(lines) < 3{
+		return BookPosition{}, fmt.Errorf("blob %q has %d lines, want at least 3", blob, len(lines))
+	}
+	if lines[1] == ""{
+		return BookPosition{}, fmt.Errorf("blob %q has no fen", blob)
+	}
+	enginedepth, err := strconv.Atoi(lines[2])
+	if err != nil{
+		return BookPosition{}, fmt.Errorf("blob %q : %v", blob, err)
+	}
+	p := NewPosition(lines[1])
+	p.Enginedepth = enginedepth
+	for _, line := range(lines[3:]){
+		m, err := BookMoveFromValues(line)
+		if err != nil{
+			return p, err
+		}
+		p.Moves = append(p.Moves, m)
+	}
+	return p, nil
+}
+
+func bookpositionfromv1blob(blob string) (BookPosition, error){
+	parts := strings.Split(blob, ";;")
+	if len(parts) != 3{
+		return BookPosition{}, fmt.Errorf("blob %q has %d parts, want 3", blob, len(parts))
+	}
+	enginedepth, err := strconv.Atoi(parts[1])
+	if err != nil{
+		return BookPosition{}, fmt.Errorf("blob %q : %v", blob, err)
+	}
+	p := NewPosition(parts[0])
+	p.Enginedepth = enginedepth
+	if parts[2] == ""{
+		return p, nil
+	}
+	for _, moveblob := range(strings.Split(parts[2], "|")){
+		m, err := BookMoveFromBlob(moveblob)
+		if err != nil{
+			return p, err
+		}
+		p.Moves = append(p.Moves, m)
+	}
+	return p, nil
+}
+
+////////////////////////////////////////////////////////////////
@@ -0,0 +1,106 @@
+package abb
+
+import(
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBlobRoundtrip(t *testing.T){
+	positions := []BookPosition{
+		{START_FEN, 20, []BookMove{
+			{"e2e4", 50, 40, 2, 7, [3]int{120, 800, 80}, []string{"e7e5", "g1f3"}},
+			{"d2d4", 30, 30, INFINITE_MINIMAX_DEPTH, 0, [3]int{}, nil},
+		}},
+		{"8/8/8/8/8/8/8/K6k w - - 0 1", 20, []BookMove{}},
+	}
+	for _, p := range(positions){
+		blob := p.Serialize()["blob"].(string)
+		if !strings.HasPrefix(blob, BLOB_VERSION + "\n"){
+			t.Errorf("blob %q has no version", blob)
+		}
+		got, err := BookPositionFromBlob(blob)
+		if err != nil{
+			t.Fatal(err)
+		}
+		if ( got.Fen != p.Fen ) || ( got.Enginedepth != p.Enginedepth ) || ( len(got.Moves) != len(p.Moves) ){
+			t.Fatalf("round trip = %v, want %v", got, p)
+		}
+		for i, m := range(p.Moves){
+			if ( got.Moves[i].Algeb != m.Algeb ) || ( got.Moves[i].Score != m.Score ) || ( got.Moves[i].Eval != m.Eval ) || ( got.Moves[i].Minimaxdepth != m.Minimaxdepth ) || ( got.Moves[i].Haspv != m.Haspv ) || ( got.Moves[i].Wdl != m.Wdl ) || ( len(got.Moves[i].Pv) != len(m.Pv) ){
+				t.Errorf("move round trip = %v, want %v", got.Moves[i], m)
+			}
+		}
+	}
+}
+
+func TestBlobV1(t *testing.T){
+	p, err := BookPositionFromBlob(START_FEN + ";;20;;e2e4;50;40;2;7;120,800,80;e7e5 g1f3|d2d4;30;30;1000;0")
+	if err != nil{
+		t.Fatal(err)
+	}
+	want := []BookMove{
+		{"e2e4", 50, 40, 2, 7, [3]int{120, 800, 80}, []string{"e7e5", "g1f3"}},
+		{"d2d4", 30, 30, INFINITE_MINIMAX_DEPTH, 0, [3]int{}, nil},
+	}
+	if ( p.Fen != START_FEN ) || ( p.Enginedepth != 20 ) || !reflect.DeepEqual(p.Moves, want){
+		t.Errorf("v1 blob = %v, want %v", p, want)
+	}
+	// terminal positions have no moves
+	if p, err := BookPositionFromBlob(START_FEN + ";;20;;"); ( err != nil ) || ( len(p.Moves) != 0 ){
+		t.Errorf("v1 blob without moves = %v %v", p, err)
+	}
+	// v2 blobs are no larger than v1 blobs but for the version line
+	v1 := START_FEN + ";;20;;e2e4;50;40;2;7;120,800,80;e7e5 g1f3|d2d4;30;30;1000;0"
+	v2 := BookPosition{START_FEN, 20, want}.Serialize()["blob"].(string)
+	if v2 != "v2\n" + START_FEN + "\n20\ne2e4,50,40,2,7,120/800/80,e7e5 g1f3\nd2d4,30,30,1000,0"{
+		t.Errorf("v2 blob = %q", v2)
+	}
+	if len(v2) > len(v1) + len(BLOB_VERSION){
+		t.Errorf("v2 blob has %d bytes, v1 blob %d bytes", len(v2), len(v1))
+	}
+	// values appended by newer versions are ignored
+	p, err = BookPositionFromBlob(BLOB_VERSION + "\n" + START_FEN + "\n20\ne2e4,50,40,2,7,1/2/3,e7e5,new")
+	if ( err != nil ) || ( len(p.Moves) != 1 ) || ( p.Moves[0].Wdl != [3]int{1, 2, 3} ) || !reflect.DeepEqual(p.Moves[0].Pv, []string{"e7e5"}){
+		t.Errorf("v2 blob with other values = %v %v", p, err)
+	}
+	if _, err := BookPositionFromBlob("v3\n" + START_FEN + "\n20"); ( err == nil ) || !strings.Contains(err.Error(), "unsupported blob version v3"){
+		t.Errorf("v3 blob error = %v", err)
+	}
+	for _, bad := range([]string{
+		"",
+		START_FEN,
+		START_FEN + ";;x;;",
+		START_FEN + ";;20;;e2e4;50",
+		START_FEN + ";;20;;e2e4;50;40;x;7",
+		BLOB_VERSION + "\n" + START_FEN,
+		BLOB_VERSION + "\n" + START_FEN + "\nx",
+		BLOB_VERSION + "\n\n20",
+		BLOB_VERSION + "\n" + START_FEN + "\n20\ne2e4,50",
+		BLOB_VERSION + "\n" + START_FEN + "\n20\ne2e4,50,40,2,7,1/2",
+		"v10\n" + START_FEN + "\n20",
+	}){
+		if p, err := BookPositionFromBlob(bad); err == nil{
+			t.Errorf("blob %q = %v, want error", bad, p)
+		}
+	}
+}
+
+func TestSyncinvalidblob(t *testing.T){
+	b := Book{Name: "test", Variantkey: "chess", Bookstore: NewMemoryStore()}
+	b.Poscache = map[string]BookPosition{"cached": NewPosition(START_FEN)}
+	err := b.Bookstore.Savebooklets(b.Id(), []Booklet{{"0", map[string]string{
+		"a": BookPosition{START_FEN, 20, []BookMove{}}.Serialize()["blob"].(string),
+		"b": START_FEN + ";;x;;",
+	}, nil}})
+	if err != nil{
+		t.Fatal(err)
+	}
+	// syncing without the invalid position would delete it on the next upload
+	if err := b.Synccache(); err == nil{
+		t.Error("booklet with invalid blob synced")
+	}
+	if _, ok := b.Poscache["cached"]; !ok{
+		t.Error("failed sync replaced the position cache")
+	}
+}
@@ -43,11 +43,15 @@ type Board struct{
 	Turnfen string
 	Castlefen string
 	Epfen string
+	Halfmove int
+	Fullmove int
+	Zobrist uint64
 }
 
 func NewBoard(variantkey string) Board{
 	b := Board{
 		Variantkey: variantkey,
+		Fullmove: 1,
 	}
 	//b.Setfromfen(START_FEN)
 	return b
@@ -71,17 +75,30 @@ func (b Board) Tostring() string{
 		rows = append(rows, strings.Join(row, " "))
 	}
 	posrep := strings.Join(rows, "\n")
-	posrep += fmt.Sprintf("\n[ %s ] %s %s %s", b.Variantkey, b.Turnfen, b.Castlefen, b.Epfen)
+	posrep += fmt.Sprintf("\n[ %s ] %s %s %s %d %d", b.Variantkey, b.Turnfen, b.Castlefen, b.Epfen, b.Halfmove, b.Fullmove)
 	return posrep
 }
 
-func (b *Board) Setfromfen(fen string){
+// Setfromfen sets the board from a fen, an invalid fen leaves
+// the board unchanged and returns the error of Parsefen
+func (b *Board) Setfromfen(fen string) error{
+	return b.Parsefen(fen)
+}
+
+// setfromfen sets the board from a fen validated by Parsefen
+func (b *Board) setfromfen(fen string){
 	b.Rep = make([]Piece, 64)
 	fenparts := strings.Split(fen, " ")
 	rawfen := fenparts[0]
 	b.Turnfen = fenparts[1]
 	b.Castlefen = fenparts[2]
 	b.Epfen = fenparts[3]
+	b.Halfmove = 0
+	b.Fullmove = 1
+	if len(fenparts) > 5{
+		b.Halfmove = str2int(fenparts[4], 0)
+		b.Fullmove = str2int(fenparts[5], 1)
+	}
 	rawfenrows := strings.Split(rawfen, "/")
 	cnt := 0
 	for _, row := range(rawfenrows){
@@ -104,6 +121,106 @@ func (b *Board) Setfromfen(fen string){
 			}
 		}		
 	}
+	b.Zobrist = b.Computezobrist()
+}
+
+// ParseFEN parses and validates a fen, the returned board has no variant set
+func ParseFEN(fen string) (Board, error){
+	b := NewBoard("")
+	err := b.Parsefen(fen)
+	return b, err
+}
+
+// Parsefen validates a fen and sets the board from it, keeping the variant,
+// the move clocks may be omitted
+func (b *Board) Parsefen(fen string) error{
+	fenparts := strings.Fields(fen)
+	if ( len(fenparts) != 4 ) && ( len(fenparts) != 6 ){
+		return fmt.Errorf("invalid fen %q : %d fields", fen, len(fenparts))
+	}
+	rawfenrows := strings.Split(fenparts[0], "/")
+	if len(rawfenrows) != 8{
+		return fmt.Errorf("invalid fen %q : %d ranks", fen, len(rawfenrows))
+	}
+	kings := map[string]int{}
+	for i, row := range(rawfenrows){
+		cnt := 0
+		for _, c := range(row){
+			if ( c >= '1' ) && ( c <= '8' ){
+				cnt += int(c - '0')
+			}else if strings.ContainsRune("pnbrqkPNBRQK", c){
+				if ( c == 'k' ) || ( c == 'K' ){
+					kings[string(c)]++
+				}
+				if ( ( c == 'p' ) || ( c == 'P' ) ) && ( ( i == 0 ) || ( i == 7 ) ){
+					return fmt.Errorf("invalid fen %q : pawn on rank %d", fen, 8-i)
+				}
+				cnt++
+			}else{
+				return fmt.Errorf("invalid fen %q : invalid piece %q", fen, c)
+			}
+		}
+		if cnt != 8{
+			return fmt.Errorf("invalid fen %q : rank %d has %d squares", fen, 8-i, cnt)
+		}
+	}
+	if ( kings["k"] > 1 ) || ( kings["K"] > 1 ){
+		return fmt.Errorf("invalid fen %q : more than one king of a color", fen)
+	}
+	if ( fenparts[1] != "w" ) && ( fenparts[1] != "b" ){
+		return fmt.Errorf("invalid fen %q : invalid side to move %q", fen, fenparts[1])
+	}
+	if fenparts[2] != "-"{
+		for i, c := range(fenparts[2]){
+			if !strings.ContainsRune("KQkq", c) || strings.ContainsRune(fenparts[2][:i], c){
+				return fmt.Errorf("invalid fen %q : invalid castling rights %q", fen, fenparts[2])
+			}
+		}
+	}
+	if fenparts[3] != "-"{
+		eprank := "6"
+		if fenparts[1] == "b"{
+			eprank = "3"
+		}
+		ep := fenparts[3]
+		if ( len(ep) != 2 ) || ( ep[0] < 'a' ) || ( ep[0] > 'h' ) || ( ep[1:] != eprank ){
+			return fmt.Errorf("invalid fen %q : invalid en passant square %q", fen, ep)
+		}
+	}
+	if len(fenparts) == 6{
+		halfmove, err := strconv.Atoi(fenparts[4])
+		if ( err != nil ) || ( halfmove < 0 ){
+			return fmt.Errorf("invalid fen %q : invalid halfmove clock %q", fen, fenparts[4])
+		}
+		fullmove, err := strconv.Atoi(fenparts[5])
+		if ( err != nil ) || ( fullmove < 1 ){
+			return fmt.Errorf("invalid fen %q : invalid fullmove number %q", fen, fenparts[5])
+		}
+	}
+	b.setfromfen(strings.Join(fenparts, " "))
+	return nil
+}
+
+// Checkalgeb tells whether the uci move is well formed and moves
+// a piece of the side to move
+func (b Board) Checkalgeb(algeb string) error{
+	if ( len(algeb) != 4 ) && ( len(algeb) != 5 ){
+		return fmt.Errorf("invalid move %q", algeb)
+	}
+	for _, sq := range([]string{algeb[0:2], algeb[2:4]}){
+		if ( sq[0] < 'a' ) || ( sq[0] > 'h' ) || ( sq[1] < '1' ) || ( sq[1] > '8' ){
+			return fmt.Errorf("invalid move %q : invalid square %q", algeb, sq)
+		}
+	}
+	if ( len(algeb) == 5 ) && !strings.Contains("qrbn", algeb[4:5]){
+		return fmt.Errorf("invalid move %q : invalid promotion piece", algeb)
+	}
+	fromi, fromj := Sqindeces(algeb[0:2])
+	p := b.Rep[index(fromi, fromj)]
+	if ( p.Kind == "-" ) || ( p.Color != b.Turn() ){
+		return fmt.Errorf("invalid move %q : no piece of the side to move on %s", algeb, algeb[0:2])
+	}
+	return nil
 }
 
 func (b Board) Tofen() string{	
@@ -135,7 +252,7 @@ func (b Board) Tofen() string{
 			buff+="/"
 		}
 	}	
-	buff += " " + b.Turnfen + " " + b.Castlefen + " " + b.Epfen + " 0 1"
+	buff += fmt.Sprintf(" %s %s %s %d %d", b.Turnfen, b.Castlefen, b.Epfen, b.Halfmove, b.Fullmove)
 	return buff
 }
 
@@ -158,125 +275,172 @@ func ijalgeb(i int, j int) string{
 	return fmt.Sprintf("%c%c", 97+i, 56-j)
 }
 
+var EMPTY_PIECE = Piece{"-", 0}
+
+// Isatomic tells whether moves of the variant are applied with atomic explosions
+func Isatomic(variantkey string) bool{
+	return variantkey == "atomic"
+}
+
+// Checkvariant tells whether the rules of the variant are implemented, only
+// standard chess and atomic moves are applied and generated, other variants
+// would be built with wrong successor positions
+func Checkvariant(variantkey string) error{
+	if !Hasmovegen(variantkey){
+		return fmt.Errorf("unsupported variant %q, only chess, standard and atomic are supported", variantkey)
+	}
+	return nil
+}
+
+// Makealgebmove applies a uci move according to the rules of the board variant,
+// atomic or standard chess, see Checkvariant
 func (b *Board) Makealgebmove(algeb string){
+	if Isatomic(b.Variantkey){
+		b.Makealgebmoveatomic(algeb)
+	}else{
+		b.Makealgebmovestandard(algeb)
+	}
+}
+
+// Makealgebmovestandard applies a uci move according to standard chess rules
+func (b *Board) Makealgebmovestandard(algeb string){
+	b.Zobrist ^= b.zobriststate()
+	b.movepiece(algeb)
+	b.Zobrist ^= b.zobriststate()
+}
+
+// Makealgebmoveatomic applies a uci move according to atomic chess rules,
+// a capture explodes the capturing piece and all pieces other than
+// pawns adjacent to the capture square
+func (b *Board) Makealgebmoveatomic(algeb string){
+	b.Zobrist ^= b.zobriststate()
+	capture := b.movepiece(algeb)
+	if capture{
+		toi, toj := Sqindeces(algeb[2:4])
+		b.setpiece(index(toi, toj), EMPTY_PIECE)
+		for di:=-1;di<2;di++{
+			for dj:=-1;dj<2;dj++{
+				if!((di==0)&&(dj==0)){
+					ni := toi+di
+					nj := toj+dj
+					if ijok(ni, nj){
+						cp := b.Rep[index(ni, nj)]
+						if (cp.Kind != "-")&&(cp.Kind != "p"){
+							b.setpiece(index(ni, nj), EMPTY_PIECE)
+						}
+					}
+				}
+			}
+		}
+		b.updatecastling("")
+	}
+	b.Zobrist ^= b.zobriststate()
+}
+
+// movepiece moves the piece of a uci move with standard chess rules,
+// handling castling, promotion, en passant and castling rights,
+// and tells whether the move was a capture
+func (b *Board) movepiece(algeb string) bool{
 	fromi, fromj := Sqindeces(algeb[0:2])
-	toi, toj := Sqindeces(algeb[2:4])	
+	toi, toj := Sqindeces(algeb[2:4])
 	fromindex := index(fromi, fromj)
 	toindex := index(toi, toj)
-	fromp := b.Rep[fromindex]	
-	top := b.Rep[toindex]	
+	fromp := b.Rep[fromindex]
+	top := b.Rep[toindex]
+	capture := top.Kind != "-"
 	if fromp.Kind == "p"{
-		if ( fromj - toj ) == 2{
-			if ijok(toi-1, toj){
-				tp := b.Rep[index(toi-1, toj)]
-				if ( tp.Kind == "p" ) && ( tp.Color == 0 ){
-					b.Epfen = ijalgeb(toi, toj+1)
-				}				
-			}
-			if ijok(toi+1, toj){
-				tp := b.Rep[index(toi+1, toj)]
-				if ( tp.Kind == "p" ) && ( tp.Color == 0 ){
-					b.Epfen = ijalgeb(toi, toj+1)
-				}				
-			}
+		if ( toi != fromi ) && ( top.Kind == "-" ){
+			// en passant
+			b.setpiece(index(toi, fromj), EMPTY_PIECE)
+			capture = true
 		}
-		if ( toj - fromj ) == 2{
-			if ijok(toi-1, toj){
-				tp := b.Rep[index(toi-1, toj)]
-				if ( tp.Kind == "p" ) && ( tp.Color == 1 ){
-					b.Epfen = ijalgeb(toi, toj-1)
-				}				
-			}
-			if ijok(toi+1, toj){
-				tp := b.Rep[index(toi+1, toj)]
-				if ( tp.Kind == "p" ) && ( tp.Color == 1 ){
-					b.Epfen = ijalgeb(toi, toj-1)
-				}				
+	}
+	b.Epfen = "-"
+	if fromp.Kind == "p"{
+		dj := toj - fromj
+		if ( dj == 2 ) || ( dj == -2 ){
+			for _, ni := range([]int{toi-1, toi+1}){
+				if ijok(ni, toj){
+					tp := b.Rep[index(ni, toj)]
+					if ( tp.Kind == "p" ) && ( tp.Color != fromp.Color ){
+						b.Epfen = ijalgeb(toi, fromj+dj/2)
+					}
+				}
 			}
 		}
 	}
-	b.Rep[fromindex] = Piece{"-", 0}
-	b.Rep[toindex] = fromp
-	cK := false
-	cQ := false
-	ck := false
-	cq := false
-	for i:=0;i<len(b.Castlefen);i++{
-		cp := b.Castlefen[i:i+1]		
-		if cp == "K"{
-			cK = true
-		}
-		if cp == "Q"{
-			cQ = true
-		}
-		if cp == "k"{
-			ck = true
-		}
-		if cp == "q"{
-			cq = true
-		}
-	}	
-	if b.Turnfen == "w"{
-		b.Turnfen = "b"
-	}else{
-		b.Turnfen = "w"
-	}	
+	b.setpiece(fromindex, EMPTY_PIECE)
+	b.setpiece(toindex, fromp)
+	if len(algeb) == 5{
+		b.setpiece(toindex, Piece{algeb[4:5], fromp.Color})
+	}
 	if fromp.Kind == "k"{
 		if algeb == "e1g1"{
-			b.Rep[63] = Piece{"-", 0}
-			b.Rep[61] = Piece{"r", 1}
-			cK = false
-			cQ = false
+			b.setpiece(63, EMPTY_PIECE)
+			b.setpiece(61, Piece{"r", 1})
 		}
 		if algeb == "e1c1"{
-			b.Rep[56] = Piece{"-", 0}
-			b.Rep[59] = Piece{"r", 1}
-			cK = false
-			cQ = false
+			b.setpiece(56, EMPTY_PIECE)
+			b.setpiece(59, Piece{"r", 1})
 		}
 		if algeb == "e8g8"{
-			b.Rep[7] = Piece{"-", 0}
-			b.Rep[5] = Piece{"r", 0}
-			ck = false
-			cq = false
+			b.setpiece(7, EMPTY_PIECE)
+			b.setpiece(5, Piece{"r", 0})
 		}
 		if algeb == "e8c8"{
-			b.Rep[0] = Piece{"-", 0}
-			b.Rep[3] = Piece{"r", 0}
-			ck = false
-			cq = false
+			b.setpiece(0, EMPTY_PIECE)
+			b.setpiece(3, Piece{"r", 0})
 		}
-	}		
-	if len(algeb) == 5{
-		b.Rep[toindex] = Piece{algeb[4:5], fromp.Color}
 	}
-	if top.Kind != "-"{
-		b.Rep[toindex]=Piece{"-", 0}
-		for di:=-1;di<2;di++{
-			for dj:=-1;dj<2;dj++{
-				if!((di==0)&&(dj==0)){
-					ni := toi+di
-					nj := toj+dj
-					if ijok(ni, nj){
-						cp := b.Rep[index(ni, nj)]
-						if (cp.Kind != "-")&&(cp.Kind != "p"){
-							b.Rep[index(ni, nj)] = Piece{"-", 0}
-						}
-					}
-				}
-			}
+	if ( fromp.Kind == "p" ) || capture{
+		b.Halfmove = 0
+	}else{
+		b.Halfmove++
+	}
+	if b.Turnfen == "w"{
+		b.Turnfen = "b"
+	}else{
+		b.Turnfen = "w"
+		b.Fullmove++
+	}
+	b.updatecastling(algeb)
+	return capture
+}
+
+// updatecastling removes the castling rights lost by touching the
+// squares of a uci move or by kings and rooks leaving their home squares
+func (b *Board) updatecastling(algeb string){
+	cK := strings.Contains(b.Castlefen, "K")
+	cQ := strings.Contains(b.Castlefen, "Q")
+	ck := strings.Contains(b.Castlefen, "k")
+	cq := strings.Contains(b.Castlefen, "q")
+	touched := func(sq string) bool{
+		if len(algeb) < 4{
+			return false
 		}
+		return ( algeb[0:2] == sq ) || ( algeb[2:4] == sq )
+	}
+	homepiece := func(sq int, kind string, color int) bool{
+		return ( b.Rep[sq].Kind == kind ) && ( b.Rep[sq].Color == color )
+	}
+	if touched("e1") || !homepiece(60, "k", 1){
+		cK = false
+		cQ = false
 	}
-	if b.Rep[63].Kind == "-"{
+	if touched("h1") || !homepiece(63, "r", 1){
 		cK = false
 	}
-	if b.Rep[56].Kind == "-"{
+	if touched("a1") || !homepiece(56, "r", 1){
 		cQ = false
 	}
-	if b.Rep[7].Kind == "-"{
+	if touched("e8") || !homepiece(4, "k", 0){
 		ck = false
+		cq = false
 	}
-	if b.Rep[0].Kind == "-"{
+	if touched("h8") || !homepiece(7, "r", 0){
+		ck = false
+	}
+	if touched("a8") || !homepiece(0, "r", 0){
 		cq = false
 	}
 	b.Castlefen = ""
@@ -299,11 +463,18 @@ func (b *Board) Makealgebmove(algeb string){
 
 ////////////////////////////////////////////////////////////////
 
-func (b Book) Makealgebmove(algeb string, fen string) string{
+func (b Book) Makealgebmove(algeb string, fen string) (string, error){
 	board := NewBoard(b.Variantkey)
-	board.Setfromfen(fen)
+	err := board.Parsefen(fen)
+	if err != nil{
+		return "", err
+	}
+	err = board.Checkalgeb(algeb)
+	if err != nil{
+		return "", err
+	}
 	board.Makealgebmove(algeb)
-	return board.Tofen()
+	return board.Tofen(), nil
 }
 
 ////////////////////////////////////////////////////////////////
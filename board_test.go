@@ -0,0 +1,276 @@
+package abb
+
+import(
+	"testing"
+)
+
+func TestMakealgebmoveVariants(t *testing.T){
+	tests := []struct{
+		variantkey string
+		fen string
+		algeb string
+		want string
+	}{
+		{"chess", "rnbqkbnr/ppp1pppp/8/3p4/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 1", "e4d5", "rnbqkbnr/ppp1pppp/8/3P4/8/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1"},
+		{"atomic", "rnbqkbnr/ppp1pppp/8/3p4/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 1", "e4d5", "rnbqkbnr/ppp1pppp/8/8/8/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1"},
+		{"atomic", "rnbqkbnr/pppp1ppp/8/8/4p3/5N2/PPPPBPPP/RNBQK2R b KQkq - 0 1", "e4f3", "rnbqkbnr/pppp1ppp/8/8/8/8/PPPP1PPP/RNBQK2R w KQkq - 0 2"},
+		{"chess", "rnbqkbnr/ppp2ppp/8/3pP3/8/8/PPPP1PPP/RNBQKBNR w KQkq d6 0 1", "e5d6", "rnbqkbnr/ppp2ppp/3P4/8/8/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1"},
+		{"atomic", "rnbqkbnr/ppp2ppp/8/3pP3/8/8/PPPP1PPP/RNBQKBNR w KQkq d6 0 1", "e5d6", "rnbqkbnr/ppp2ppp/8/8/8/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1"},
+		{"chess", "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1g1", "r3k2r/8/8/8/8/8/8/R4RK1 b kq - 1 1"},
+		{"chess", "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "a1a8", "R3k2r/8/8/8/8/8/8/4K2R b Kk - 0 1"},
+		{"atomic", "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "a1a8", "4k2r/8/8/8/8/8/8/4K2R b Kk - 0 1"},
+		{"chess", "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1e2", "r3k2r/8/8/8/8/8/4K3/R6R b kq - 1 1"},
+		{"chess", "8/P7/8/8/8/8/8/k6K w - - 0 1", "a7a8q", "Q7/8/8/8/8/8/8/k6K b - - 0 1"},
+		{"chess", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", "e2e4", "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1"},
+		{"chess", "rnbqkbnr/pppp1ppp/8/8/4p3/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", "d2d4", "rnbqkbnr/pppp1ppp/8/8/3Pp3/8/PPP1PPPP/RNBQKBNR b KQkq d3 0 1"},
+	}
+	for _, test := range(tests){
+		b := NewBoard(test.variantkey)
+		b.Setfromfen(test.fen)
+		b.Makealgebmove(test.algeb)
+		if got := b.Tofen(); got != test.want{
+			t.Errorf("%s %s %s = %s, want %s", test.variantkey, test.fen, test.algeb, got, test.want)
+		}
+	}
+}
+
+func TestClocks(t *testing.T){
+	tests := []struct{
+		fen string
+		algebs []string
+		want string
+	}{
+		{START_FEN, []string{"g1f3", "g8f6", "f3g1", "f6g8"}, "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 4 3"},
+		{START_FEN, []string{"g1f3", "g8f6", "e2e4"}, "rnbqkb1r/pppppppp/5n2/8/4P3/5N2/PPPP1PPP/RNBQKB1R b KQkq - 0 2"},
+		{"rnbqkbnr/pppp1ppp/8/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R b KQkq - 7 12", []string{"b8c6", "f3e5"}, "r1bqkbnr/pppp1ppp/2n5/8/4P3/8/PPPP1PPP/RNBQKB1R b KQkq - 0 13"},
+	}
+	for _, test := range(tests){
+		b := NewBoard("atomic")
+		b.Setfromfen(test.fen)
+		for _, algeb := range(test.algebs){
+			b.Makealgebmove(algeb)
+		}
+		if got := b.Tofen(); got != test.want{
+			t.Errorf("%s %v = %s, want %s", test.fen, test.algebs, got, test.want)
+		}
+	}
+}
+
+func TestLegalMovesAtomic(t *testing.T){
+	tests := []struct{
+		variantkey string
+		fen string
+		legal []string
+		illegal []string
+		result string
+	}{
+		// kings cannot capture in atomic
+		{"atomic", "8/8/8/8/8/8/1q6/K6k w - - 0 1", []string{}, []string{"a1b2"}, "0-1"},
+		{"chess", "8/8/8/8/8/8/1q6/K6k w - - 0 1", []string{"a1b2"}, []string{"a1a2", "a1b1"}, "*"},
+		// touching kings are never in check
+		{"atomic", "8/8/8/8/8/8/r7/Kk6 w - - 0 1", []string{"a1b2"}, []string{"a1a2"}, "*"},
+		// a capture exploding the own king is illegal
+		{"atomic", "2R4k/8/8/8/8/8/2n5/1K6 w - - 0 1", []string{"c8c7", "b1a2"}, []string{"c8c2"}, "*"},
+		// a capture exploding the opponent king is legal even in check
+		{"atomic", "k7/1n6/8/8/8/8/6q1/1R5K w - - 0 1", []string{"b1b7"}, []string{"h1g2", "h1g1"}, "*"},
+		// the exploded king loses
+		{"atomic", "8/8/8/8/8/8/8/7K b - - 0 1", []string{}, []string{}, "1-0"},
+		// castling through an attacked square is illegal
+		{"atomic", "3rk3/8/8/8/8/8/8/R3K3 w Q - 0 1", []string{"a1d1", "e1f1"}, []string{"e1c1", "e1d1"}, "*"},
+		{"atomic", "4k3/8/8/8/8/8/8/R3K3 w Q - 0 1", []string{"e1c1"}, []string{}, "*"},
+	}
+	for _, test := range(tests){
+		b := NewBoard(test.variantkey)
+		b.Setfromfen(test.fen)
+		for _, move := range(test.legal){
+			if !b.Islegal(move){
+				t.Errorf("%s %s move %s not legal", test.variantkey, test.fen, move)
+			}
+		}
+		for _, move := range(test.illegal){
+			if b.Islegal(move){
+				t.Errorf("%s %s move %s legal", test.variantkey, test.fen, move)
+			}
+		}
+		if _, result := b.Gameover(); result != test.result{
+			t.Errorf("%s %s result = %s, want %s", test.variantkey, test.fen, result, test.result)
+		}
+	}
+}
+
+func TestPerft(t *testing.T){
+	tests := []struct{
+		name string
+		variantkey string
+		fen string
+		nodes []int
+	}{
+		{"atomic start", "atomic", START_FEN, []int{20, 400, 8902, 197326}},
+		{"atomic programfox 1", "atomic", "rn2kb1r/1pp1p2p/p2q1pp1/3P4/2P3b1/4PN2/PP3PPP/R2QKB1R b KQkq - 0 1", []int{40, 1238, 45237}},
+		{"atomic programfox 2", "atomic", "rn1qkb1r/p5pp/2p5/3p4/N3P3/5P2/PPP4P/R1BQK3 w Qkq - 0 1", []int{28, 833, 23353}},
+		{"chess start", "chess", START_FEN, []int{20, 400, 8902, 197281}},
+		{"chess kiwipete", "chess", "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", []int{48, 2039, 97862}},
+		{"chess en passant pins", "chess", "8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1", []int{14, 191, 2812, 43238}},
+		{"chess promotions", "chess", "r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1", []int{6, 264, 9467}},
+	}
+	for _, test := range(tests){
+		b := NewBoard(test.variantkey)
+		b.Setfromfen(test.fen)
+		for i, want := range(test.nodes){
+			depth := i + 1
+			if testing.Short() && ( want > 50000 ){
+				continue
+			}
+			if got := b.Perft(depth); got != want{
+				t.Errorf("%s perft %d = %d, want %d", test.name, depth, got, want)
+			}
+		}
+	}
+}
+
+func TestParseFEN(t *testing.T){
+	valid := []string{
+		START_FEN,
+		"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1",
+		"8/8/8/8/8/8/8/7K b - -",
+	}
+	for _, fen := range(valid){
+		if _, err := ParseFEN(fen); err != nil{
+			t.Errorf("ParseFEN(%q) = %v", fen, err)
+		}
+	}
+	invalid := []string{
+		"",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP w KQkq - 0 1",
+		"rnbqkbnr/pppppppp/9/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+		"rnbqkbnr/pppppppp/7/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+		"rnbqkbnr/ppppxppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR x KQkq - 0 1",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkx - 0 1",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KKkq - 0 1",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq e3 0 1",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - -1 1",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 0",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0",
+		"Pnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBKKBNR w - - 0 1",
+	}
+	for _, fen := range(invalid){
+		if _, err := ParseFEN(fen); err == nil{
+			t.Errorf("ParseFEN(%q) succeeded", fen)
+		}
+		board := NewBoard("atomic")
+		if err := board.Setfromfen(fen); ( err == nil ) || ( board.Rep != nil ){
+			t.Errorf("Setfromfen(%q) = %v, board %v", fen, err, board.Rep)
+		}
+		if _, err := Fen2zobrist(fen); err == nil{
+			t.Errorf("Fen2zobrist(%q) succeeded", fen)
+		}
+	}
+	b := Book{Variantkey: "atomic"}
+	if _, err := b.Makealgebmove("e2e4", "rnbqkbnr/pppppppp/8/8 w KQkq - 0 1"); err == nil{
+		t.Error("move on invalid fen succeeded")
+	}
+	for _, algeb := range([]string{"e2", "e2e9", "e3e4", "e7e5", "e7e8k"}){
+		if _, err := b.Makealgebmove(algeb, START_FEN); err == nil{
+			t.Errorf("invalid move %s succeeded", algeb)
+		}
+	}
+}
+
+func TestZobrist(t *testing.T){
+	tests := []struct{
+		algebs []string
+		key uint64
+	}{
+		{[]string{}, 0x463b96181691fc9c},
+		{[]string{"e2e4"}, 0x823c9b50fd114196},
+		{[]string{"e2e4", "d7d5"}, 0x0756b94461c50fb0},
+		{[]string{"e2e4", "d7d5", "e4e5"}, 0x662fafb965db29d4},
+		{[]string{"e2e4", "d7d5", "e4e5", "f7f5"}, 0x22a48b5a8e47ff78},
+		{[]string{"e2e4", "d7d5", "e4e5", "f7f5", "e1e2"}, 0x652a607ca3f242c1},
+		{[]string{"e2e4", "d7d5", "e4e5", "f7f5", "e1e2", "e8f7"}, 0x00fdd303c946bdd9},
+		{[]string{"a2a4", "b7b5", "h2h4", "b5b4", "c2c4"}, 0x3c8123ea7b067637},
+		{[]string{"a2a4", "b7b5", "h2h4", "b5b4", "c2c4", "b4c3", "a1a3"}, 0x5c3f9b829b279560},
+	}
+	for _, test := range(tests){
+		b := NewBoard("chess")
+		b.Setfromfen(START_FEN)
+		for _, algeb := range(test.algebs){
+			b.Makealgebmove(algeb)
+		}
+		if b.Zobrist != test.key{
+			t.Errorf("%v zobrist = %016x, want %016x", test.algebs, b.Zobrist, test.key)
+		}
+		if key, err := Fen2zobrist(b.Tofen()); ( err != nil ) || ( key != test.key ){
+			t.Errorf("%v fen zobrist = %016x %v, want %016x", test.algebs, key, err, test.key)
+		}
+	}
+	// the incremental key follows explosions, castling and en passant
+	b := NewBoard("atomic")
+	b.Setfromfen("r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1")
+	for _, algeb := range([]string{"e1g1", "b4c3", "a2a4", "e8c8", "c2c4", "b6c4", "d5e6"}){
+		if !b.Islegal(algeb){
+			t.Fatalf("%s not legal in %s", algeb, b.Tofen())
+		}
+		b.Makealgebmove(algeb)
+		if b.Zobrist != b.Computezobrist(){
+			t.Errorf("incremental zobrist %016x after %s, want %016x", b.Zobrist, algeb, b.Computezobrist())
+		}
+	}
+}
+
+func TestSAN(t *testing.T){
+	for _, c := range([]struct{
+		variantkey string
+		fen string
+		algeb string
+		san string
+	}{
+		{"chess", START_FEN, "e2e4", "e4"},
+		{"chess", START_FEN, "g1f3", "Nf3"},
+		{"chess", "r3k2r/8/8/3N1N2/8/8/4P3/R3K2R w KQkq - 0 1", "d5e7", "Nde7"},
+		{"chess", "r3k2r/8/8/3N1N2/8/8/4P3/R3K2R w KQkq - 0 1", "e1g1", "O-O"},
+		{"chess", "r3k2r/8/8/3N1N2/8/8/4P3/R3K2R w KQkq - 0 1", "e1c1", "O-O-O"},
+		{"chess", "r3k2r/8/8/3N1N2/8/8/4P3/R3K2R w KQkq - 0 1", "a1a8", "Rxa8+"},
+		{"chess", "4k3/8/8/8/8/8/4K3/R6R w - - 0 1", "a1d1", "Rad1"},
+		{"chess", "4k3/8/8/1N6/8/1N6/8/4K3 w - - 0 1", "b3d4", "N3d4"},
+		{"chess", "4k3/8/8/1N3N2/8/1N6/8/4K3 w - - 0 1", "b5d4", "Nb5d4"},
+		{"chess", "6k1/5ppp/8/8/8/8/8/R3K3 w - - 0 1", "a1a8", "Ra8#"},
+		{"chess", "1r2k3/P7/8/8/8/8/8/4K3 w - - 0 1", "a7b8q", "axb8=Q+"},
+		{"chess", "4k3/8/8/3pP3/8/8/8/4K3 w - d6 0 1", "e5d6", "exd6"},
+		// atomic : kings do not give check, exploding the king mates
+		{"atomic", "rnbqkbnr/ppp2ppp/8/3pp3/4P3/5N2/PPPP1PPP/RNBQKB1R w KQkq - 0 3", "f3e5", "Nxe5"},
+		{"atomic", "rnbqkbnr/ppp2ppp/8/3pp3/4P3/5N2/PPPP1PPP/RNBQKB1R w KQkq - 0 3", "f1b5", "Bb5+"},
+		{"atomic", "rnbqkbnr/pppp1ppp/8/4p3/8/5N2/PPPPPPPP/RNBQKB1R w KQkq - 0 2", "f3e5", "Nxe5"},
+		{"atomic", "rnbqkbnr/pppppppp/8/6N1/8/8/PPPPPPPP/RNBQKB1R w KQkq - 0 1", "g5f7", "Nxf7#"},
+		{"atomic", "8/8/8/8/8/8/3k4/R3K3 w - - 0 1", "a1a2", "Ra2"},
+	}){
+		board := NewBoard(c.variantkey)
+		board.Setfromfen(c.fen)
+		if got := board.ToSAN(c.algeb); got != c.san{
+			t.Errorf("%s %s to san = %s, want %s", c.fen, c.algeb, got, c.san)
+		}
+		if got, err := board.ParseSAN(c.san); ( err != nil ) || ( got != c.algeb ){
+			t.Errorf("%s parse san %s = %s %v, want %s", c.fen, c.san, got, err, c.algeb)
+		}
+	}
+	board := NewBoard("chess")
+	board.Setfromfen("4k3/8/8/1N3N2/8/1N6/8/4K3 w - - 0 1")
+	for _, san := range([]string{"Nd4", "Ke3x", "Qd1", "e4", "O-O"}){
+		if algeb, err := board.ParseSAN(san); err == nil{
+			t.Errorf("parse san %s = %s, want error", san, algeb)
+		}
+	}
+	for _, fen := range([]string{START_FEN, "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1"}){
+		for _, variantkey := range([]string{"chess", "atomic"}){
+			board := NewBoard(variantkey)
+			board.Setfromfen(fen)
+			for _, algeb := range(board.LegalMoves()){
+				if got, err := board.ParseSAN(board.ToSAN(algeb)); ( err != nil ) || ( got != algeb ){
+					t.Errorf("%s %s san round trip = %s %v", variantkey, algeb, got, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,184 @@
+////////////////////////////////////////////////////////////////
+
+package abb
+
+////////////////////////////////////////////////////////////////
+
+import(
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+////////////////////////////////////////////////////////////////
+
+const BOLT_BOOKS_BUCKET = "books"
+const BOLT_BOOKLETS_BUCKET = "booklets"
+
+////////////////////////////////////////////////////////////////
+
+// BoltStore is a BookStore persisted to a local bbolt database file,
+// book metadata is stored as json in the books bucket, booklets are
+// nested buckets of the book bucket in the booklets bucket, each
+// mapping posids to position blobs, or holding the packed positions
+// of a binary booklet under BOOKLET_PACKED_KEY
+type BoltStore struct{
+	db *bolt.DB
+}
+
+// NewBoltStore opens or creates the database file at path
+func NewBoltStore(path string) (*BoltStore, error){
+	fmt.Println("--> opening local store", path)
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil{
+		return nil, fmt.Errorf("local store %s could not be opened: %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error{
+		_, err := tx.CreateBucketIfNotExists([]byte(BOLT_BOOKS_BUCKET))
+		if err != nil{
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(BOLT_BOOKLETS_BUCKET))
+		return err
+	})
+	if err != nil{
+		db.Close()
+		return nil, err
+	}
+	fmt.Println("--> local store opened")
+	return &BoltStore{db}, nil
+}
+
+func (s *BoltStore) getmeta(tx *bolt.Tx, id string) (map[string]interface{}, error){
+	meta := make(map[string]interface{})
+	data := tx.Bucket([]byte(BOLT_BOOKS_BUCKET)).Get([]byte(id))
+	if data == nil{
+		return meta, nil
+	}
+	err := json.Unmarshal(data, &meta)
+	return meta, err
+}
+
+func (s *BoltStore) putmeta(tx *bolt.Tx, id string, meta map[string]interface{}) error{
+	data, err := json.Marshal(meta)
+	if err != nil{
+		return err
+	}
+	return tx.Bucket([]byte(BOLT_BOOKS_BUCKET)).Put([]byte(id), data)
+}
+
+func (s *BoltStore) Listbooks() ([]string, error){
+	ids := []string{}
+	err := s.db.View(func(tx *bolt.Tx) error{
+		return tx.Bucket([]byte(BOLT_BOOKS_BUCKET)).ForEach(func(k, v []byte) error{
+			ids = append(ids, string(k))
+			return nil
+		})
+	})
+	return ids, err
+}
+
+func (s *BoltStore) Storebook(id string, meta map[string]interface{}) error{
+	return s.db.Update(func(tx *bolt.Tx) error{
+		return s.putmeta(tx, id, meta)
+	})
+}
+
+func (s *BoltStore) Loadbook(id string) (map[string]interface{}, error){
+	var meta map[string]interface{}
+	err := s.db.View(func(tx *bolt.Tx) error{
+		if tx.Bucket([]byte(BOLT_BOOKS_BUCKET)).Get([]byte(id)) == nil{
+			return nil
+		}
+		var err error
+		meta, err = s.getmeta(tx, id)
+		return err
+	})
+	return meta, err
+}
+
+func (s *BoltStore) Updatefields(id string, fields map[string]interface{}) error{
+	return s.db.Update(func(tx *bolt.Tx) error{
+		meta, err := s.getmeta(tx, id)
+		if err != nil{
+			return err
+		}
+		for key, value := range(fields){
+			meta[key] = value
+		}
+		return s.putmeta(tx, id, meta)
+	})
+}
+
+func (s *BoltStore) Loadbooklets(id string) ([]Booklet, error){
+	booklets := []Booklet{}
+	err := s.db.View(func(tx *bolt.Tx) error{
+		bookb := tx.Bucket([]byte(BOLT_BOOKLETS_BUCKET)).Bucket([]byte(id))
+		if bookb == nil{
+			return nil
+		}
+		return bookb.ForEach(func(k, v []byte) error{
+			bookletb := bookb.Bucket(k)
+			if bookletb == nil{
+				return nil
+			}
+			booklet := Booklet{
+				Id: string(k),
+				Positions: make(map[string]string),
+			}
+			err := bookletb.ForEach(func(posid, blob []byte) error{
+				if string(posid) == BOOKLET_PACKED_KEY{
+					booklet.Packed = append([]byte{}, blob...)
+					return nil
+				}
+				booklet.Positions[string(posid)] = string(blob)
+				return nil
+			})
+			booklets = append(booklets, booklet)
+			return err
+		})
+	})
+	return booklets, err
+}
+
+func (s *BoltStore) Savebooklets(id string, booklets []Booklet) error{
+	return s.db.Update(func(tx *bolt.Tx) error{
+		bookb, err := tx.Bucket([]byte(BOLT_BOOKLETS_BUCKET)).CreateBucketIfNotExists([]byte(id))
+		if err != nil{
+			return err
+		}
+		for _, booklet := range(booklets){
+			if bookb.Bucket([]byte(booklet.Id)) != nil{
+				err = bookb.DeleteBucket([]byte(booklet.Id))
+				if err != nil{
+					return err
+				}
+			}
+			bookletb, err := bookb.CreateBucket([]byte(booklet.Id))
+			if err != nil{
+				return err
+			}
+			for posid, blob := range(booklet.Positions){
+				err = bookletb.Put([]byte(posid), []byte(blob))
+				if err != nil{
+					return err
+				}
+			}
+			if booklet.Packed != nil{
+				err = bookletb.Put([]byte(BOOKLET_PACKED_KEY), booklet.Packed)
+				if err != nil{
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Close() error{
+	return s.db.Close()
+}
+
+////////////////////////////////////////////////////////////////
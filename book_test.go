@@ -0,0 +1,689 @@
+package abb
+
+import(
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const E2E4_FEN = "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1"
+
+var testscript = FakeScript{
+	START_FEN: {
+		"info depth 19 seldepth 24 multipv 1 score cp 45 nodes 900 nps 90000 time 10 pv e2e4",
+		"info string classical evaluation enabled",
+		"info depth 20 seldepth 25 multipv 1 score cp 50 wdl 120 800 80 nodes 1000 nps 100000 hashfull 12 tbhits 3 time 10 pv e2e4 e7e5",
+		"info depth 20 seldepth 25 multipv 2 score cp 30 nodes 1000 nps 100000 time 10 pv d2d4 d7d5",
+		"info depth 20 seldepth 25 multipv 3 score mate -3 nodes 1000 nps 100000 time 10 pv g1f3",
+		"bestmove e2e4 ponder e7e5",
+	},
+	E2E4_FEN: {
+		"info depth 20 seldepth 25 multipv 1 score cp -40 nodes 1000 nps 100000 time 10 pv e7e5 g1f3",
+		"info depth 20 seldepth 25 multipv 2 score cp -60 nodes 1000 nps 100000 time 10 pv d7d5",
+	},
+}
+
+func newtestbook(t testing.TB) Book{
+	return Book{
+		Name: "test",
+		Variantkey: "atomic",
+		Rootfen: START_FEN,
+		Mod: 10,
+		Analysisdepth: 5,
+		Enginedepth: 20,
+		Numcycles: 1,
+		Batchsize: 1,
+		Minimaxafter: 1,
+		Cutoff: 1000,
+		Widths: []int{1},
+		Poskey: POSKEY_POSID,
+		Pvlength: DEFAULT_PV_LENGTH,
+		Bookstore: NewMemoryStore(),
+		Engine: NewFakeEngine(t, testscript),
+		Poscache: make(map[string]BookPosition),
+		Poslock: &sync.RWMutex{},
+	}
+}
+
+func TestAnalyze(t *testing.T){
+	b := newtestbook(t)
+	defer b.Engine.Close()
+	p, err := b.Analyze(START_FEN)
+	if err != nil{
+		t.Fatal(err)
+	}
+	if p.Enginedepth != 20{
+		t.Errorf("engine depth = %d, want 20", p.Enginedepth)
+	}
+	want := []BookMove{
+		{"e2e4", 50, 50, INFINITE_MINIMAX_DEPTH, 0, [3]int{120, 800, 80}, []string{"e7e5"}},
+		{"d2d4", 30, 30, INFINITE_MINIMAX_DEPTH, 0, [3]int{}, []string{"d7d5"}},
+		{"g1f3", -INF_SCORE + 3, -INF_SCORE + 3, INFINITE_MINIMAX_DEPTH, 0, [3]int{}, []string{}},
+	}
+	if len(p.Moves) != len(want){
+		t.Fatalf("moves = %v, want %v", p.Moves, want)
+	}
+	for i, m := range(want){
+		if !reflect.DeepEqual(p.Moves[i], m){
+			t.Errorf("move %d = %v, want %v", i, p.Moves[i], m)
+		}
+	}
+	p, err = b.Analyze("8/8/8/8/8/8/8/K6k w - - 0 1")
+	if ( err != nil ) || ( len(p.Moves) != 0 ){
+		t.Errorf("unscripted position moves = %v, want none", p.Moves)
+	}
+	b.Pvlength = 0
+	p, _ = b.Analyze(START_FEN)
+	if ( len(p.Moves[0].Pv) != 0 ) || !reflect.DeepEqual(p.Moves[0].Line(), []string{"e2e4"}){
+		t.Errorf("pv not bounded : %v", p.Moves[0])
+	}
+}
+
+func TestAddoneSelectMinimax(t *testing.T){
+	b := newtestbook(t)
+	defer b.Engine.Close()
+	if fen := b.Select(0); fen != START_FEN{
+		t.Fatalf("select on empty book = %q, want root", fen)
+	}
+	if fen := b.Addone(); fen != START_FEN{
+		t.Fatalf("first add = %q, want root", fen)
+	}
+	if fen := b.Addone(); Fen2posid(fen) != Fen2posid(E2E4_FEN){
+		t.Fatalf("second add = %q, want %q", fen, E2E4_FEN)
+	}
+	if len(b.Poscache) != 2{
+		t.Fatalf("cached positions = %d, want 2", len(b.Poscache))
+	}
+	b.Minimaxout()
+	root, _ := b.Getpos(START_FEN)
+	evals := map[string]int{}
+	for _, m := range(root.Moves){
+		evals[m.Algeb] = m.Eval
+	}
+	if evals["e2e4"] != 40{
+		t.Errorf("minimaxed e2e4 = %d, want 40", evals["e2e4"])
+	}
+	if evals["d2d4"] != 30{
+		t.Errorf("unexplored d2d4 = %d, want 30", evals["d2d4"])
+	}
+	if err := b.Store(); err != nil{
+		t.Fatal(err)
+	}
+	if err := b.Uploadcache(); err != nil{
+		t.Fatal(err)
+	}
+	synced := b
+	if err := synced.Synccache(); err != nil{
+		t.Fatal(err)
+	}
+	if len(synced.Poscache) != 2{
+		t.Errorf("synced positions = %d, want 2", len(synced.Poscache))
+	}
+}
+
+func TestCopybook(t *testing.T){
+	b := newtestbook(t)
+	defer b.Engine.Close()
+	if err := Copybook(b, NewMemoryStore()); err == nil{
+		t.Error("copy of unstored book succeeded")
+	}
+	if err := b.Store(); err != nil{
+		t.Fatal(err)
+	}
+	b.Addone()
+	if err := b.Uploadcache(); err != nil{
+		t.Fatal(err)
+	}
+	b.Cutoff = 5
+	to := NewMemoryStore()
+	if err := Copybook(b, to); err != nil{
+		t.Fatal(err)
+	}
+	meta, _ := to.Getbook(b.Id())
+	if meta["cutoff"] != "1000"{
+		t.Errorf("copied cutoff = %v, want stored 1000", meta["cutoff"])
+	}
+	booklets, _ := to.Loadbooklets(b.Id())
+	if len(booklets) != 1{
+		t.Errorf("copied booklets = %d, want 1", len(booklets))
+	}
+}
+
+func TestMigrateposkeys(t *testing.T){
+	b := newtestbook(t)
+	defer b.Engine.Close()
+	b.Addone()
+	b.Addone()
+	if err := b.Migrateposkeys(POSKEY_ZOBRIST); err != nil{
+		t.Fatal(err)
+	}
+	key, _ := Fen2zobristkeyhex(E2E4_FEN)
+	if _, ok := b.Poscache[key]; !ok{
+		t.Fatalf("position not keyed by zobrist key : %v", b.Poscache)
+	}
+	// invalid fens have no zobrist key instead of sharing the empty key
+	for _, fen := range([]string{"", "8/8/8 w - - 0 1"}){
+		if err := b.StorePosition(NewPosition(fen)); err == nil{
+			t.Errorf("position %q stored with zobrist key", fen)
+		}
+	}
+	if _, ok := b.Poscache[""]; ok{
+		t.Error("position stored under the empty key")
+	}
+	if err := b.Uploadcache(); err != nil{
+		t.Fatal(err)
+	}
+	synced := b
+	if err := synced.Synccache(); err != nil{
+		t.Fatal(err)
+	}
+	if _, ok := synced.Getpos(START_FEN); !ok || ( len(synced.Poscache) != 2 ){
+		t.Fatalf("synced positions = %v", synced.Poscache)
+	}
+	synced.Minimaxout()
+	root, _ := synced.Getpos(START_FEN)
+	for _, m := range(root.Moves){
+		if ( m.Algeb == "e2e4" ) && ( m.Eval != 40 ){
+			t.Errorf("minimaxed e2e4 = %d, want 40", m.Eval)
+		}
+	}
+	if err := b.Migrateposkeys("nosuchkey"); err == nil{
+		t.Error("migration to unknown key succeeded")
+	}
+	// a position without zobrist key fails the migration and keeps the keys
+	b.Migrateposkeys(POSKEY_POSID)
+	b.StorePosition(NewPosition("8/8/8 w - - 0 1"))
+	if err := b.Migrateposkeys(POSKEY_ZOBRIST); ( err == nil ) || ( b.Poskey != POSKEY_POSID ){
+		t.Errorf("migration of invalid fen = %v, poskey %s", err, b.Poskey)
+	}
+}
+
+func TestPolyglotexport(t *testing.T){
+	board := NewBoard("chess")
+	board.Setfromfen("r3k3/P7/8/8/8/8/4P3/4K2R w Kq - 0 1")
+	for algeb, want := range(map[string]uint16{"e2e4": 796, "e1g1": 263, "a7a8q": 19512, "a7b8n": 7225}){
+		if got := Polyglotmove(board, algeb); got != want{
+			t.Errorf("polyglot move %s = %d, want %d", algeb, got, want)
+		}
+	}
+	b := newtestbook(t)
+	defer b.Engine.Close()
+	b.Addone()
+	b.Addone()
+	entries, err := b.Polyglotentries()
+	if err != nil{
+		t.Fatal(err)
+	}
+	// the mated move of the test script gets no weight
+	if len(entries) != 4{
+		t.Fatalf("entries = %v, want 4", entries)
+	}
+	if ( entries[0].Key != 0x463b96181691fc9c ) || ( entries[0].Move != 796 ) || ( entries[0].Weight != POLYGLOT_MAX_WEIGHT ){
+		t.Errorf("first entry = %v, want e2e4 from the start position", entries[0])
+	}
+	for i := 1; i < len(entries); i++{
+		if entries[i-1].Key > entries[i].Key{
+			t.Errorf("entries not sorted : %v", entries)
+		}
+	}
+	var buf bytes.Buffer
+	n, err := b.Exportpolyglot(&buf)
+	if ( err != nil ) || ( n != 4 ) || ( buf.Len() != 64 ){
+		t.Fatalf("export = %d entries %d bytes %v", n, buf.Len(), err)
+	}
+}
+
+func TestPolyglotimport(t *testing.T){
+	board := NewBoard("chess")
+	board.Setfromfen("r3k3/P7/8/8/8/8/4P3/4K2R w Kq - 0 1")
+	for _, algeb := range([]string{"e2e4", "e1g1", "a7a8q", "a7b8n"}){
+		if got := Polyglotalgeb(board, Polyglotmove(board, algeb)); got != algeb{
+			t.Errorf("polyglot algeb of %s = %s", algeb, got)
+		}
+	}
+	src := newtestbook(t)
+	defer src.Engine.Close()
+	src.Addone()
+	src.Addone()
+	var buf bytes.Buffer
+	src.Exportpolyglot(&buf)
+	entries, err := Readpolyglot(&buf)
+	if ( err != nil ) || ( len(entries) != 4 ){
+		t.Fatalf("read = %v %v, want 4 entries", entries, err)
+	}
+	b := newtestbook(t)
+	defer b.Engine.Close()
+	if numpos, err := b.Importpolyglot(entries); ( err != nil ) || ( numpos != 2 ){
+		t.Fatalf("imported positions = %d %v, want 2", numpos, err)
+	}
+	p, ok := b.Getpos(START_FEN)
+	if !ok || ( p.Enginedepth != 0 ) || ( len(p.Moves) != 2 ){
+		t.Fatalf("imported root = %v", p)
+	}
+	if mli := p.Getmovelist().Items; ( mli[0].Algeb != "e2e4" ) || ( mli[0].Score != 0 ){
+		t.Errorf("imported best move = %v, want e2e4 with score 0", mli[0])
+	}
+	// imported positions are analyzed when selected
+	if fen := b.Select(0); fen != START_FEN{
+		t.Errorf("selected %s, want the imported root", fen)
+	}
+	b.Addone()
+	if p, _ := b.Getpos(START_FEN); p.Enginedepth != b.Enginedepth{
+		t.Errorf("root engine depth after analysis = %d", p.Enginedepth)
+	}
+	// the weight scores of the imported e2e4 position stay out of the minimax
+	b.Minimaxout()
+	p, _ = b.Getpos(START_FEN)
+	if mli := p.Getmovelist().Items; ( mli[0].Algeb != "e2e4" ) || ( mli[0].Eval != 50 ){
+		t.Errorf("minimaxed best move = %v, want e2e4 with eval 50", mli[0])
+	}
+	if line, err := b.Epd(BookPosition{E2E4_FEN, 0, []BookMove{{Algeb: "e7e5"}}}); ( err != nil ) || strings.Contains(line, "bm"){
+		t.Errorf("imported position epd = %q %v, want no best move", line, err)
+	}
+	// the pgn ends at the imported e2e4 position without commenting its weight scores
+	pgn, err := b.Pgn()
+	if ( err != nil ) || !strings.Contains(pgn, "1. e4 {[%eval 0.50]") || strings.Contains(pgn, "e5 {") || strings.Contains(pgn, "d5 {"){
+		t.Errorf("pgn of imported position = %q %v", pgn, err)
+	}
+}
+
+func TestPgn(t *testing.T){
+	for _, c := range([]struct{
+		score int
+		turn int
+		want string
+	}{{45, WHITE, "0.45"}, {45, BLACK, "-0.45"}, {INF_SCORE - 3, WHITE, "#3"}, {INF_SCORE - 2, BLACK, "#-2"}}){
+		if got := Pgneval(c.score, c.turn); got != c.want{
+			t.Errorf("pgn eval %d %d = %s, want %s", c.score, c.turn, got, c.want)
+		}
+	}
+	if got := Pgnwdl([3]int{100, 850, 50}, BLACK); got != "50/850/100"{
+		t.Errorf("pgn wdl of black = %s", got)
+	}
+	b := newtestbook(t)
+	defer b.Engine.Close()
+	b.Addone()
+	b.Addone()
+	b.Minimaxout()
+	pgn, err := b.Pgn()
+	if err != nil{
+		t.Fatal(err)
+	}
+	for _, want := range([]string{"[Variant \"Atomic\"]\n", "\n1. e4 {[%eval 0.40] score 0.50 wdl 120/800/80 pv e5} 1... e5 {[%eval 0.40] score 0.40 pv Nf3} *\n"}){
+		if !strings.Contains(pgn, want){
+			t.Errorf("pgn %q does not contain %q", pgn, want)
+		}
+	}
+	if strings.Contains(pgn, "[FEN"){
+		t.Errorf("pgn of the start position has a FEN header : %q", pgn)
+	}
+	// the best move e2e4 scores 50 and fails the cutoff
+	b.Variantkey = "chess"
+	b.Cutoff = 40
+	pgn, _ = b.Pgn()
+	if !strings.Contains(pgn, "[Variant \"Standard\"]\n") || !strings.HasSuffix(pgn, "\n\n*\n"){
+		t.Errorf("pgn with the best move outside the cutoff = %q", pgn)
+	}
+}
+
+func TestCorruptmoves(t *testing.T){
+	b := newtestbook(t)
+	defer b.Engine.Close()
+	b.Variantkey = "chess"
+	for _, p := range([]BookPosition{
+		{START_FEN, 20, []BookMove{
+			{"e2", 60, 60, INFINITE_MINIMAX_DEPTH, 0, [3]int{}, nil},
+			{"e2e4", 50, 50, INFINITE_MINIMAX_DEPTH, 0, [3]int{}, nil},
+			{"e7e5", 40, 40, INFINITE_MINIMAX_DEPTH, 0, [3]int{}, nil},
+			{"d2d4x", 30, 30, INFINITE_MINIMAX_DEPTH, 0, [3]int{}, nil},
+		}},
+		{E2E4_FEN, 20, []BookMove{
+			{"e7e5", -40, -40, INFINITE_MINIMAX_DEPTH, 0, [3]int{}, nil},
+			{"z9z9", -45, -45, INFINITE_MINIMAX_DEPTH, 0, [3]int{}, nil},
+		}},
+	}){
+		b.StorePosition(p)
+	}
+	// corrupt moves of synced blobs are skipped instead of panicking
+	b.Minimaxout()
+	if p, _ := b.Getpos(START_FEN); p.Moves[1].Eval != 40{
+		t.Errorf("minimaxed e2e4 = %v, want 40", p.Moves[1])
+	}
+	pgn, err := b.Pgn()
+	if ( err != nil ) || !strings.Contains(pgn, "1. e4 ") || strings.Contains(pgn, "e2 ") || strings.Contains(pgn, "d2d4x"){
+		t.Errorf("pgn with corrupt moves = %q %v", pgn, err)
+	}
+	entries, err := b.Polyglotentries()
+	if ( err != nil ) || ( len(entries) != 2 ){
+		t.Errorf("polyglot entries with corrupt moves = %v %v", entries, err)
+	}
+}
+
+func TestEpdexport(t *testing.T){
+	b := newtestbook(t)
+	defer b.Engine.Close()
+	b.Addone()
+	b.Addone()
+	var buf bytes.Buffer
+	n, err := b.Exportepd(&buf)
+	if ( err != nil ) || ( n != 2 ){
+		t.Fatalf("export = %d %v, want 2 positions", n, err)
+	}
+	want := "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - bm e5; ce -40; acd 20; hmvc 0; fmvn 1;\n" +
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - bm e4; ce 50; acd 20; hmvc 0; fmvn 1;\n"
+	if buf.String() != want{
+		t.Errorf("epd = %q, want %q", buf.String(), want)
+	}
+	// the best move is chosen by eval and reported with its eval
+	p := BookPosition{"4k3/8/8/8/8/8/8/R3K3 w Q - 3 12", 20, []BookMove{
+		{Algeb: "a1a8", Score: 900, Eval: 900},
+		{Algeb: "e1c1", Score: 950, Eval: 200},
+	}}
+	line, err := b.Epd(p)
+	if ( err != nil ) || ( line != "4k3/8/8/8/8/8/8/R3K3 w Q - bm Ra8+; ce 900; acd 20; hmvc 3; fmvn 12;" ){
+		t.Errorf("epd = %q %v", line, err)
+	}
+	if a, err := Parseepd(line); ( err != nil ) || ( a.Fen != p.Fen ){
+		t.Errorf("parsed epd fen = %q %v, want %q", a.Fen, err, p.Fen)
+	}
+}
+
+func TestImportanalysis(t *testing.T){
+	src := newtestbook(t)
+	defer src.Engine.Close()
+	src.Addone()
+	src.Addone()
+	var buf bytes.Buffer
+	src.Exportepd(&buf)
+	b := newtestbook(t)
+	defer b.Engine.Close()
+	numpos, err := b.Importanalysis(&buf)
+	if ( err != nil ) || ( numpos != 2 ){
+		t.Fatalf("epd import = %d %v, want 2 positions", numpos, err)
+	}
+	p, _ := b.Getpos(E2E4_FEN)
+	if p.Fen != E2E4_FEN{
+		t.Errorf("imported epd fen = %s, want %s", p.Fen, E2E4_FEN)
+	}
+	if ( p.Enginedepth != 20 ) || ( len(p.Moves) != 1 ) || !reflect.DeepEqual(p.Moves[0], BookMove{"e7e5", -40, -40, INFINITE_MINIMAX_DEPTH, 0, [3]int{}, nil}){
+		t.Errorf("imported epd position = %v", p)
+	}
+	jsonl := `{"fen": "` + START_FEN + `", "depth": 25, "moves": [{"move": "d2d4", "score": 35}, {"move": "Nf3", "score": 20}]}
+{"fen": "` + E2E4_FEN + `", "depth": 10, "moves": [{"move": "c7c5", "score": -30}]}
+`
+	numpos, err = b.Importanalysis(strings.NewReader(jsonl))
+	if ( err != nil ) || ( numpos != 1 ){
+		t.Fatalf("json import = %d %v, want 1 position", numpos, err)
+	}
+	if p, _ := b.Getpos(START_FEN); ( p.Enginedepth != 25 ) || ( len(p.Moves) != 2 ) || ( p.Moves[1].Algeb != "g1f3" ){
+		t.Errorf("deeper analysis not merged : %v", p)
+	}
+	if p, _ := b.Getpos(E2E4_FEN); p.Enginedepth != 20{
+		t.Errorf("shallower analysis merged : %v", p)
+	}
+	// analyses without moves or without positive depth are rejected
+	for _, bad := range([]string{
+		`{"fen": "` + START_FEN + `", "depth": 30, "moves": [{"move": "e2e5", "score": 0}]}`,
+		"8/8/8 w - - bm e4;",
+		"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - ce 20; acd 30;",
+		`{"fen": "` + E2E4_FEN + `", "depth": 30, "moves": []}`,
+		`{"fen": "` + E2E4_FEN + `", "moves": [{"move": "e7e5", "score": -40}]}`,
+		`{"fen": "` + E2E4_FEN + `", "depth": -5, "moves": [{"move": "e7e5", "score": -40}]}`,
+		"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - bm e5; ce -40;",
+	}){
+		if _, err := b.Importanalysis(strings.NewReader(bad)); err == nil{
+			t.Errorf("import of %q succeeded, want error", bad)
+		}
+	}
+	b.Minimaxout()
+	if p, _ := b.Getpos(E2E4_FEN); ( p.Enginedepth != 20 ) || ( len(p.Moves) != 1 ){
+		t.Errorf("rejected analyses replaced the e2e4 position : %v", p)
+	}
+	if p, _ := b.Getpos(START_FEN); ( p.Moves[0].Algeb != "d2d4" ) || ( p.Moves[0].Eval >= MATE_SCORE ){
+		t.Errorf("minimaxed root after rejected analyses = %v", p.Moves)
+	}
+}
+
+// failingstore is a memory store failing every metadata update
+type failingstore struct{
+	*MemoryStore
+}
+
+func (s failingstore) Updatefields(id string, fields map[string]interface{}) error{
+	return fmt.Errorf("updating %s failed", id)
+}
+
+func TestAddbatch(t *testing.T){
+	b := newtestbook(t)
+	b.Widths = []int{2}
+	b.Enginepool = NewEnginePool([]*Engine{b.Engine, NewFakeEngine(t, testscript)})
+	if fens, err := b.Addbatch(2); ( err != nil ) || ( len(fens) != 1 ) || ( fens[0] != START_FEN ){
+		t.Fatalf("first batch = %v %v, want the root only", fens, err)
+	}
+	fens, err := b.Addbatch(2)
+	if ( err != nil ) || ( len(fens) != 2 ){
+		t.Fatalf("second batch = %v %v, want 2 positions", fens, err)
+	}
+	for _, fen := range(fens){
+		if _, ok := b.Getpos(fen); !ok || ( fen == START_FEN ){
+			t.Errorf("batch position %s not stored", fen)
+		}
+	}
+	if p, _ := b.Getpos(E2E4_FEN); len(p.Moves) != 2{
+		t.Errorf("e2e4 analysis = %v", p)
+	}
+	// a batch without analysis leaves lastadd alone, a failing store is reported
+	b.Enginepool.Close()
+	b.Enginepool = nil
+	b.Engine = NewFakeEngine(t, FakeScript{START_FEN: {"crash 10"}})
+	b.Engineretries = 0
+	b.Poscache = make(map[string]BookPosition)
+	b.Bookstore = failingstore{NewMemoryStore()}
+	if fens, err := b.Addbatch(1); ( err != nil ) || ( len(fens) != 0 ){
+		t.Errorf("failed batch = %v %v", fens, err)
+	}
+	b.Engine.Close()
+	b.Engine = NewFakeEngine(t, testscript)
+	if fens, err := b.Addbatch(1); ( err == nil ) || ( len(fens) != 1 ){
+		t.Errorf("batch with failing store = %v %v, want the root and an error", fens, err)
+	}
+	b.Engine.Close()
+	b.Engine = nil
+	if _, err := b.Addbatch(2); err == nil{
+		t.Error("batch without engine succeeded")
+	}
+	if fen := b.Addone(); fen != ""{
+		t.Errorf("add without engine = %q, want none", fen)
+	}
+}
+
+func TestHandshake(t *testing.T){
+	eng := NewFakeEngine(t, testscript)
+	defer eng.Close()
+	if ( eng.Name != "Fake Engine" ) || ( eng.Author != "abb" ) || ( len(eng.Options) != 5 ){
+		t.Fatalf("handshake = %q %q %v", eng.Name, eng.Author, eng.Options)
+	}
+	want := EngineOption{Name: "MultiPV", Type: "spin", Default: "1", Min: 1, Max: 500}
+	if opt := eng.Options["multipv"]; ( opt.Name != want.Name ) || ( opt.Type != want.Type ) || ( opt.Default != want.Default ) || ( opt.Min != want.Min ) || ( opt.Max != want.Max ){
+		t.Errorf("multipv option = %+v, want %+v", opt, want)
+	}
+	if vars := eng.Options["uci_variant"].Vars; ( len(vars) != 2 ) || ( vars[1] != "atomic" ){
+		t.Errorf("variant option vars = %v", vars)
+	}
+	opt, err := parseOption("option name Skill Level type spin default 20 min 0 max 20")
+	if ( err != nil ) || ( opt.Name != "Skill Level" ) || ( opt.Max != 20 ){
+		t.Errorf("option with spaces = %+v %v", opt, err)
+	}
+	for _, bad := range([]Options{
+		{UCI_Variant: "crazyhouse"},
+		{UCI_Variant: "atomic", MultiPV: 1000},
+		{UCI_Variant: "atomic", Hash: 64, Threads: 16},
+	}){
+		var opterr *OptionError
+		if err := eng.SetOptions(bad); !errors.As(err, &opterr){
+			t.Errorf("set options %+v = %v, want option error", bad, err)
+		}
+	}
+	if err := eng.SetOptions(Options{UCI_Variant: "atomic", Hash: 64, Threads: 2, MultiPV: 250, Ponder: true}); err != nil{
+		t.Error(err)
+	}
+	if err := eng.IsReady(); err != nil{
+		t.Error(err)
+	}
+	b := newtestbook(t)
+	defer b.Engine.Close()
+	if b.Serialize()["enginename"] != "Fake Engine"{
+		t.Errorf("engine name not recorded : %v", b.Serialize())
+	}
+	b.Engineretries = 0
+	b.Variantkey = "crazyhouse"
+	if p, err := b.Analyze(START_FEN); ( err == nil ) || ( len(p.Moves) != 0 ){
+		t.Errorf("analysis with unsupported variant = %v %v, want error", p, err)
+	}
+}
+
+func TestEnginecrash(t *testing.T){
+	script := FakeScript{START_FEN: append([]string{"crash 2"}, testscript[START_FEN]...)}
+	b := newtestbook(t)
+	b.Engine.Close()
+	b.Engine = NewFakeEngine(t, script)
+	b.Engineretries = 2
+	p, err := b.Analyze(START_FEN)
+	if ( err != nil ) || ( len(p.Moves) != 3 ){
+		t.Errorf("analysis with restarts = %v %v", p, err)
+	}
+	if p, err := b.Analyze(E2E4_FEN); ( err != nil ) || ( p.Enginedepth != 0 ){
+		t.Errorf("analysis after restart = %v %v", p, err)
+	}
+	b.Engine.Close()
+	b.Engine = NewFakeEngine(t, script)
+	defer b.Engine.Close()
+	b.Engineretries = 1
+	if _, err := b.Analyze(START_FEN); err == nil{
+		t.Error("analysis of a crashing engine succeeded")
+	}
+	// option errors do not restart the engine, so its next start still crashes
+	b.Engine.Close()
+	b.Engine = NewFakeEngine(t, FakeScript{START_FEN: append([]string{"crash 1"}, testscript[START_FEN]...)})
+	b.Variantkey = "crazyhouse"
+	var opterr *OptionError
+	if _, err := b.Analyze(START_FEN); !errors.As(err, &opterr){
+		t.Errorf("analysis with unsupported variant = %v, want option error", err)
+	}
+	b.Variantkey = "atomic"
+	b.Engineretries = 0
+	if _, err := b.Analyze(START_FEN); !b.Engine.Failed(err){
+		t.Errorf("analysis after option error = %v, want engine failure", err)
+	}
+}
+
+func TestAnalysislimits(t *testing.T){
+	// the search is stopped before the third line and the stale fourth line,
+	// which has the move of the deeper first line, are searched at depth 2
+	script := FakeScript{START_FEN: {
+		"info depth 1 seldepth 1 multipv 1 score cp 20 nodes 20 nps 1000 time 1 pv e2e4",
+		"info depth 1 seldepth 1 multipv 2 score cp 10 nodes 20 nps 1000 time 1 pv g1f3",
+		"info depth 1 seldepth 1 multipv 3 score cp 5 nodes 20 nps 1000 time 1 pv c2c4",
+		"info depth 1 seldepth 1 multipv 4 score cp 0 nodes 20 nps 1000 time 1 pv d2d4",
+		"wait",
+		"info depth 2 seldepth 2 multipv 1 score cp 30 nodes 400 nps 1000 time 1 pv d2d4",
+		"info depth 2 seldepth 2 multipv 2 score cp 25 nodes 400 nps 1000 time 1 pv e2e4",
+	}}
+	b := newtestbook(t)
+	b.Engine.Close()
+	b.Engine = NewFakeEngine(t, script)
+	defer b.Engine.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err := b.Engine.GoContext(ctx, SearchLimits{Nodes: 1000}, "", HighestDepthOnly)
+	if ( err != context.Canceled ) || ( results == nil ) || ( len(results.Results) != 3 ){
+		t.Fatalf("canceled search = %v %v", results, err)
+	}
+	for i, want := range([]struct{
+		move string
+		depth int
+	}{{"d2d4", 2}, {"e2e4", 2}, {"c2c4", 1}}){
+		if r := results.Results[i]; ( r.BestMoves[0] != want.move ) || ( r.Depth != want.depth ){
+			t.Errorf("line %d = %s at depth %d, want %s at depth %d", i+1, r.BestMoves[0], r.Depth, want.move, want.depth)
+		}
+	}
+	b.Analysismode = ANALYSIS_MOVETIME
+	b.Enginemovetime = 1000
+	if limits := b.Searchlimits(); limits != ( SearchLimits{Movetime: 1000} ){
+		t.Errorf("movetime limits = %+v", limits)
+	}
+	b.Enginetimeout = 50
+	p, err := b.Analyze(START_FEN)
+	if ( err != nil ) || ( p.Enginedepth != 2 ) || ( len(p.Moves) != 3 ) || ( p.Moves[0].Algeb != "d2d4" ){
+		t.Errorf("timed out analysis = %v %v", p, err)
+	}
+	// a timeout without results is an error that is not retried
+	b.Engine.Close()
+	b.Engine = NewFakeEngine(t, FakeScript{START_FEN: {"wait"}})
+	b.Engineretries = 3
+	if _, err := b.Analyze(START_FEN); !errors.Is(err, context.DeadlineExceeded){
+		t.Errorf("timed out analysis without results = %v", err)
+	}
+	// an engine ignoring stop is closed and restarted
+	stoptimeout := ENGINE_STOP_TIMEOUT
+	ENGINE_STOP_TIMEOUT = 50 * time.Millisecond
+	defer func(){ ENGINE_STOP_TIMEOUT = stoptimeout }()
+	b.Engine.Close()
+	b.Engine = NewFakeEngine(t, FakeScript{START_FEN: {"hang"}})
+	b.Engineretries = 1
+	if _, err := b.Analyze(START_FEN); !errors.Is(err, ErrEngineNotStopped){
+		t.Errorf("analysis of a hanging engine = %v", err)
+	}
+	// an engine sending invalid output is killed, so that the rest of its
+	// output is not read as the answer to the next position
+	for _, script := range([]FakeScript{{START_FEN: {"info depth x", "wait"}}, {START_FEN: {"bestmove"}}}){
+		b.Engine.Close()
+		b.Engine = NewFakeEngine(t, script)
+		b.Engineretries = 0
+		if _, err := b.Analyze(START_FEN); !errors.Is(err, ErrEngineKilled) || !b.Engine.Failed(err){
+			t.Errorf("analysis with invalid output %v = %v, want a killed engine", script, err)
+		}
+		if err := b.Engine.Restart(); err != nil{
+			t.Fatal(err)
+		}
+		if p, err := b.Analyze(E2E4_FEN); ( err != nil ) || ( len(p.Moves) != 0 ){
+			t.Errorf("analysis after restart = %v %v", p, err)
+		}
+	}
+	for _, c := range([]struct{
+		mode string
+		depth, nodes, movetime, timeout int
+	}{{ANALYSIS_NODES, 20, 0, 0, 1000}, {ANALYSIS_MOVETIME, 20, 0, 0, 1000}, {ANALYSIS_DEPTH, 0, 0, 0, 0}, {"time", 20, 0, 0, 0}}){
+		b.Analysismode, b.Enginedepth, b.Enginenodes, b.Enginemovetime, b.Enginetimeout = c.mode, c.depth, c.nodes, c.movetime, c.timeout
+		if err := b.Checksearch(); err == nil{
+			t.Errorf("search %+v accepted", c)
+		}
+	}
+	b.Enginetimeout = 1000
+	b.Analysismode = ANALYSIS_DEPTH
+	if err := b.Checksearch(); err != nil{
+		t.Errorf("infinite search with timeout = %v", err)
+	}
+}
+
+func TestEngineinfo(t *testing.T){
+	res := Results{}
+	for _, line := range(testscript[START_FEN]){
+		if err := res.addLineToResults(line); err != nil{
+			t.Fatal(err)
+		}
+	}
+	if ( len(res.Strings) != 1 ) || ( res.Strings[0] != "classical evaluation enabled" ){
+		t.Errorf("info strings = %v", res.Strings)
+	}
+	r := res.results[scoreKey{Depth: 20, MultiPV: 1}]
+	if ( r.WDL != [3]int{120, 800, 80} ) || ( r.Hashfull != 12 ) || ( r.TBHits != 3 ) || ( r.Score != 50 ) || ( len(r.BestMoves) != 2 ){
+		t.Errorf("info = %+v", r)
+	}
+}
@@ -0,0 +1,319 @@
+////////////////////////////////////////////////////////////////
+
+package abb
+
+////////////////////////////////////////////////////////////////
+
+import(
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+////////////////////////////////////////////////////////////////
+
+// booklet encodings of a book, text booklets map position keys to text
+// blobs, binary booklets hold all positions packed in raw bytes, compressed
+// booklets are binary booklets compressed if that makes them smaller
+const BOOKLET_TEXT = "text"
+const BOOKLET_BINARY = "binary"
+const BOOKLET_COMPRESSED = "compressed"
+
+// key of the packed positions of a binary booklet in the stores, it is
+// never a position key
+const BOOKLET_PACKED_KEY = "packed"
+
+// stored booklets larger than this exceed the firestore document limit
+const BOOKLET_MAX_SIZE = 1 << 20
+
+const BOOKLET_BINARY_VERSION = 1
+const BOOKLET_FLAG_COMPRESSED = 1
+
+////////////////////////////////////////////////////////////////
+
+// Checkbookletencoding tells whether the booklet encoding is known
+func Checkbookletencoding(encoding string) error{
+	if ( encoding != BOOKLET_TEXT ) && ( encoding != BOOKLET_BINARY ) && ( encoding != BOOKLET_COMPRESSED ){
+		return fmt.Errorf("invalid booklet encoding %q", encoding)
+	}
+	return nil
+}
+
+// Packmove packs a uci move in 16 bits, from square in bits 0-5,
+// to square in bits 6-11, promotion piece in bits 12-14, null moves,
+// drops and other promotions cannot be packed
+func Packmove(algeb string) (uint16, error){
+	if ( ( len(algeb) != 4 ) && ( len(algeb) != 5 ) ) || !issquare(algeb[0:2]) || !issquare(algeb[2:4]) || ( algeb[0:2] == algeb[2:4] ){
+		return 0, fmt.Errorf("move %q cannot be packed", algeb)
+	}
+	fromi, fromj := Sqindeces(algeb[0:2])
+	toi, toj := Sqindeces(algeb[2:4])
+	move := uint16(index(fromi, fromj)) | uint16(index(toi, toj)) << 6
+	if len(algeb) > 4{
+		promotion, ok := POLYGLOT_PROMOTIONS[algeb[4:5]]
+		if !ok{
+			return 0, fmt.Errorf("move %q cannot be packed", algeb)
+		}
+		move |= promotion << 12
+	}
+	return move, nil
+}
+
+func issquare(sq string) bool{
+	return ( sq[0] >= 'a' ) && ( sq[0] <= 'h' ) && ( sq[1] >= '1' ) && ( sq[1] <= '8' )
+}
+
+// Unpackmove returns the uci move of a packed move, moves that Packmove
+// cannot produce are an error
+func Unpackmove(move uint16) (string, error){
+	from := int(move & 63)
+	to := int(move >> 6 & 63)
+	promotion := move >> 12
+	if ( from == to ) || ( promotion > 4 ){
+		return "", fmt.Errorf("packed move %#04x is invalid", move)
+	}
+	algeb := ijalgeb(from % 8, from / 8) + ijalgeb(to % 8, to / 8)
+	for kind, code := range(POLYGLOT_PROMOTIONS){
+		if code == promotion{
+			algeb += kind
+		}
+	}
+	return algeb, nil
+}
+
+type bookletwriter struct{
+	buf bytes.Buffer
+	err error
+}
+
+func (w *bookletwriter) uvarint(x uint64){
+	var tmp [binary.MaxVarintLen64]byte
+	w.buf.Write(tmp[:binary.PutUvarint(tmp[:], x)])
+}
+
+func (w *bookletwriter) varint(x int64){
+	var tmp [binary.MaxVarintLen64]byte
+	w.buf.Write(tmp[:binary.PutVarint(tmp[:], x)])
+}
+
+func (w *bookletwriter) move(algeb string){
+	move, err := Packmove(algeb)
+	if err != nil{
+		if w.err == nil{
+			w.err = err
+		}
+		return
+	}
+	binary.Write(&w.buf, binary.BigEndian, move)
+}
+
+func (w *bookletwriter) str(s string){
+	w.uvarint(uint64(len(s)))
+	w.buf.WriteString(s)
+}
+
+// Encodepositions packs the positions in binary format, move algebs are
+// packed in 16 bits and numbers are varints
+func Encodepositions(positions []BookPosition) ([]byte, error){
+	w := bookletwriter{}
+	w.uvarint(uint64(len(positions)))
+	for _, p := range(positions){
+		w.str(p.Fen)
+		w.uvarint(uint64(p.Enginedepth))
+		w.uvarint(uint64(len(p.Moves)))
+		for _, m := range(p.Moves){
+			w.move(m.Algeb)
+			w.varint(int64(m.Score))
+			w.varint(int64(m.Eval))
+			w.uvarint(uint64(m.Minimaxdepth))
+			w.uvarint(uint64(m.Haspv))
+			for _, x := range(m.Wdl){
+				w.uvarint(uint64(x))
+			}
+			w.uvarint(uint64(len(m.Pv)))
+			for _, algeb := range(m.Pv){
+				w.move(algeb)
+			}
+		}
+		if w.err != nil{
+			return nil, fmt.Errorf("position %s : %v", p.Fen, w.err)
+		}
+	}
+	return w.buf.Bytes(), nil
+}
+
+type bookletreader struct{
+	r *bytes.Reader
+	err error
+}
+
+func (r *bookletreader) uvarint() int{
+	if r.err != nil{
+		return 0
+	}
+	x, err := binary.ReadUvarint(r.r)
+	r.err = err
+	return int(x)
+}
+
+func (r *bookletreader) varint() int{
+	if r.err != nil{
+		return 0
+	}
+	x, err := binary.ReadVarint(r.r)
+	r.err = err
+	return int(x)
+}
+
+func (r *bookletreader) move() string{
+	var move uint16
+	if r.err == nil{
+		r.err = binary.Read(r.r, binary.BigEndian, &move)
+	}
+	if r.err != nil{
+		return ""
+	}
+	algeb, err := Unpackmove(move)
+	r.err = err
+	return algeb
+}
+
+func (r *bookletreader) str() string{
+	n := r.uvarint()
+	if ( r.err != nil ) || ( n > r.r.Len() ){
+		if r.err == nil{
+			r.err = io.ErrUnexpectedEOF
+		}
+		return ""
+	}
+	buf := make([]byte, n)
+	_, r.err = io.ReadFull(r.r, buf)
+	return string(buf)
+}
+
+// count reads a count that cannot exceed the remaining bytes
+func (r *bookletreader) count() int{
+	n := r.uvarint()
+	if ( r.err == nil ) && ( n > r.r.Len() ){
+		r.err = io.ErrUnexpectedEOF
+	}
+	if r.err != nil{
+		return 0
+	}
+	return n
+}
+
+// Decodepositions unpacks positions packed by Encodepositions
+func Decodepositions(data []byte) ([]BookPosition, error){
+	r := bookletreader{r: bytes.NewReader(data)}
+	positions := []BookPosition{}
+	numpos := r.count()
+	for i := 0; i < numpos; i++{
+		p := NewPosition(r.str())
+		p.Enginedepth = r.uvarint()
+		nummoves := r.count()
+		for j := 0; j < nummoves; j++{
+			m := BookMove{Algeb: r.move()}
+			m.Score = r.varint()
+			m.Eval = r.varint()
+			m.Minimaxdepth = r.uvarint()
+			m.Haspv = r.uvarint()
+			for k := range(m.Wdl){
+				m.Wdl[k] = r.uvarint()
+			}
+			pvlen := r.count()
+			for k := 0; k < pvlen; k++{
+				m.Pv = append(m.Pv, r.move())
+			}
+			p.Moves = append(p.Moves, m)
+		}
+		if r.err != nil{
+			return nil, fmt.Errorf("invalid packed positions : %v", r.err)
+		}
+		positions = append(positions, p)
+	}
+	if r.err != nil{
+		return nil, fmt.Errorf("invalid packed positions : %v", r.err)
+	}
+	return positions, nil
+}
+
+// Packbooklet returns a binary booklet of the positions, compressed if
+// compress is set and compression makes it smaller
+func Packbooklet(id string, positions []BookPosition, compress bool) (Booklet, error){
+	data, err := Encodepositions(positions)
+	if err != nil{
+		return Booklet{}, fmt.Errorf("booklet %s : %v", id, err)
+	}
+	flags := byte(0)
+	if compress{
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		_, err := zw.Write(data)
+		if err == nil{
+			err = zw.Close()
+		}
+		if err != nil{
+			return Booklet{}, err
+		}
+		if buf.Len() < len(data){
+			data = buf.Bytes()
+			flags |= BOOKLET_FLAG_COMPRESSED
+		}
+	}
+	return Booklet{
+		Id: id,
+		Positions: map[string]string{},
+		Packed: append([]byte{BOOKLET_BINARY_VERSION, flags}, data...),
+	}, nil
+}
+
+// Bookletpositions returns the positions of a binary or text booklet, a
+// booklet with an invalid blob is an error as uploading without the skipped
+// positions would delete them
+func Bookletpositions(booklet Booklet) ([]BookPosition, error){
+	packed := booklet.Packed
+	if packed == nil{
+		positions := []BookPosition{}
+		for _, blob := range(booklet.Positions){
+			p, err := BookPositionFromBlob(blob)
+			if err != nil{
+				return nil, fmt.Errorf("booklet %s : %v", booklet.Id, err)
+			}
+			positions = append(positions, p)
+		}
+		return positions, nil
+	}
+	if ( len(packed) < 2 ) || ( packed[0] != BOOKLET_BINARY_VERSION ){
+		return nil, fmt.Errorf("booklet %s : unknown binary booklet version", booklet.Id)
+	}
+	data := packed[2:]
+	if packed[1] & BOOKLET_FLAG_COMPRESSED != 0{
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil{
+			return nil, fmt.Errorf("booklet %s : %v", booklet.Id, err)
+		}
+		data, err = io.ReadAll(zr)
+		if err != nil{
+			return nil, fmt.Errorf("booklet %s : %v", booklet.Id, err)
+		}
+	}
+	positions, err := Decodepositions(data)
+	if err != nil{
+		return nil, fmt.Errorf("booklet %s : %v", booklet.Id, err)
+	}
+	return positions, nil
+}
+
+// Bookletsize returns the stored size of the booklet
+func Bookletsize(booklet Booklet) int{
+	size := len(booklet.Packed)
+	for _, blob := range(booklet.Positions){
+		size += len(blob)
+	}
+	return size
+}
+
+////////////////////////////////////////////////////////////////
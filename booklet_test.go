@@ -0,0 +1,137 @@
+package abb
+
+import(
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPackmove(t *testing.T){
+	for _, fen := range([]string{START_FEN, "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", "1r2k3/P7/8/8/8/8/8/4K3 w - - 0 1"}){
+		board := NewBoard("chess")
+		board.Setfromfen(fen)
+		for _, algeb := range(board.LegalMoves()){
+			move, err := Packmove(algeb)
+			if err != nil{
+				t.Errorf("move %s : %v", algeb, err)
+			}
+			if got, err := Unpackmove(move); ( err != nil ) || ( got != algeb ){
+				t.Errorf("packed move %s = %s %v", algeb, got, err)
+			}
+		}
+	}
+	for _, algeb := range([]string{"a7a8n", "h2h1b", "b7c8r", "g7h8q"}){
+		move, err := Packmove(algeb)
+		got, unpackerr := Unpackmove(move)
+		if ( err != nil ) || ( unpackerr != nil ) || ( got != algeb ){
+			t.Errorf("promotion %s = %s %v %v", algeb, got, err, unpackerr)
+		}
+	}
+	// null moves, drops and king promotions of antichess are not packed
+	for _, algeb := range([]string{"", "e2", "e2e", "0000", "P@e4", "e2e4e5", "i2i4", "e0e1", "e9e8", "e2e2", "a7a8k", "a7a8Q"}){
+		if move, err := Packmove(algeb); err == nil{
+			t.Errorf("move %q packed as %#04x", algeb, move)
+		}
+	}
+	// unknown promotion codes and null moves are not unpacked
+	for _, move := range([]uint16{0, 0x0208, 0x5e30, 0x7e30, 0x8e30}){
+		if algeb, err := Unpackmove(move); err == nil{
+			t.Errorf("packed move %#04x unpacked as %s", move, algeb)
+		}
+	}
+	positions := []BookPosition{{START_FEN, 20, []BookMove{{"P@e4", 0, 0, 0, 0, [3]int{}, nil}}}}
+	if _, err := Packbooklet("0", positions, false); err == nil{
+		t.Error("booklet with drop move packed")
+	}
+	positions[0].Moves[0] = BookMove{"e2e4", 0, 0, 0, 0, [3]int{}, []string{"e7e5", "0000"}}
+	if _, err := Encodepositions(positions); err == nil{
+		t.Error("position with null pv move packed")
+	}
+}
+
+func TestBookletencodings(t *testing.T){
+	b := Book{Name: "test", Variantkey: "chess", Mod: 2, Bookstore: NewMemoryStore()}
+	b.Poscache = map[string]BookPosition{}
+	for _, p := range([]BookPosition{
+		{START_FEN, 20, []BookMove{
+			{"e2e4", 50, 40, 2, 7, [3]int{120, 800, 80}, []string{"e7e5", "g1f3", "b8c6"}},
+			{"d2d4", 30, 30, INFINITE_MINIMAX_DEPTH, 0, [3]int{}, nil},
+			{"g1f3", -25, -30, 1, 3, [3]int{20, 900, 80}, []string{"d7d5"}},
+		}},
+		{"rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e6 0 2", 18, []BookMove{
+			{"g1f3", 45, 45, 1, 1, [3]int{90, 850, 60}, []string{"b8c6", "f1b5", "a7a6", "b5a4"}},
+		}},
+		// an imported position without pv and a terminal position without moves
+		{"1r2k3/P7/8/8/8/8/8/4K3 w - - 0 1", 0, []BookMove{{"a7b8q", 0, 0, INFINITE_MINIMAX_DEPTH, 0, [3]int{}, nil}}},
+		{"8/8/8/8/8/8/8/K6k w - - 0 1", 20, []BookMove{}},
+	}){
+		key, _ := b.Poskeyof(p.Fen)
+		b.Poscache[key] = p
+	}
+	want := b.Poscache
+	store := b.Bookstore.(*MemoryStore)
+	sizes := map[string]int{}
+	// each encoding reads the booklets written with the previous one
+	for _, encoding := range([]string{BOOKLET_TEXT, BOOKLET_COMPRESSED, BOOKLET_BINARY, BOOKLET_TEXT}){
+		b.Bookletencoding = encoding
+		if err := b.Uploadcache(); err != nil{
+			t.Fatal(err)
+		}
+		booklets, _ := store.Loadbooklets(b.Id())
+		sizes[encoding] = 0
+		for _, booklet := range(booklets){
+			if ( booklet.Packed != nil ) != ( encoding != BOOKLET_TEXT ) || ( ( booklet.Packed != nil ) && ( len(booklet.Positions) > 0 ) ){
+				t.Errorf("%s booklet %s = %v %v", encoding, booklet.Id, booklet.Positions, booklet.Packed)
+			}
+			sizes[encoding] += Bookletsize(booklet)
+		}
+		b.Poscache = nil
+		if err := b.Synccache(); err != nil{
+			t.Fatal(err)
+		}
+		if len(b.Poscache) != len(want){
+			t.Fatalf("%s synced %d positions, want %d", encoding, len(b.Poscache), len(want))
+		}
+		for key, p := range(want){
+			if got := b.Poscache[key]; !reflect.DeepEqual(got.Serialize(), p.Serialize()){
+				t.Errorf("%s synced %v, want %v", encoding, got, p)
+			}
+		}
+	}
+	if sizes[BOOKLET_BINARY] >= sizes[BOOKLET_TEXT]{
+		t.Errorf("binary booklets %d bytes, text booklets %d bytes", sizes[BOOKLET_BINARY], sizes[BOOKLET_TEXT])
+	}
+	for _, bad := range([][]byte{{}, {1}, {2, 0}, {1, 1, 0, 0, 0}, {1, 0, 5}}){
+		if _, err := Bookletpositions(Booklet{"0", map[string]string{}, bad}); err == nil{
+			t.Errorf("packed booklet %v decoded, want error", bad)
+		}
+	}
+	// a corrupt booklet fails the sync like an invalid text blob
+	for _, corrupt := range([][]byte{{1, 0, 5}, corruptpromotion(t)}){
+		store.Savebooklets(b.Id(), []Booklet{{"0", map[string]string{}, corrupt}})
+		if err := b.Synccache(); ( err == nil ) || !strings.Contains(err.Error(), "booklet 0"){
+			t.Errorf("sync of corrupt booklet %v = %v", corrupt, err)
+		}
+	}
+}
+
+// corruptpromotion returns a binary booklet whose a7a8q move has the
+// promotion code 5
+func corruptpromotion(t *testing.T) []byte{
+	positions := []BookPosition{{"1r2k3/P7/8/8/8/8/8/4K3 w - - 0 1", 20, []BookMove{{"a7a8q", 0, 0, 0, 0, [3]int{}, nil}}}}
+	data, err := Encodepositions(positions)
+	if err != nil{
+		t.Fatal(err)
+	}
+	move, _ := Packmove("a7a8q")
+	i := bytes.Index(data, []byte{byte(move >> 8), byte(move)})
+	if i < 0{
+		t.Fatalf("a7a8q not found in %v", data)
+	}
+	data[i] = data[i] & 0x0f | 0x50
+	if _, err := Decodepositions(data); ( err == nil ) || !strings.Contains(err.Error(), "packed move"){
+		t.Errorf("decoded corrupt promotion = %v", err)
+	}
+	return append([]byte{BOOKLET_BINARY_VERSION, 0}, data...)
+}
@@ -0,0 +1,156 @@
+////////////////////////////////////////////////////////////////
+
+package abb
+
+////////////////////////////////////////////////////////////////
+
+import(
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+////////////////////////////////////////////////////////////////
+
+const DEFAULT_ENGINE_PATH = "engines/stockfish9"
+const DEFAULT_ENGINE_HASH = 64
+const DEFAULT_ENGINE_THREADS = 1
+const DEFAULT_ENGINE_MULTIPV = 250
+const DEFAULT_ENGINE_RETRIES = 3
+const DEFAULT_CREDENTIALS_FILE = "firebase/fbsacckey.json"
+
+////////////////////////////////////////////////////////////////
+
+// Config holds what is needed to open a builder
+type Config struct{
+	Store string // book store kind : firestore, local or memory
+	Credentialsfile string // firestore service account key file
+	Bookroot string // firestore collection of the books
+	Storepath string // database file of the local store
+	Enginepath string // engine executable, no engine is started if empty
+	Numengines int // number of engines analyzing in parallel
+	Bookfields map[string]string // book settings by book meta key, see Book.Setfields
+}
+
+// NewConfig returns the config set by the environment
+func NewConfig() Config{
+	return Config{
+		Store: Envstr("BOOKSTORE", "firestore"),
+		Credentialsfile: Envstr("FIRESTORECREDENTIALS", DEFAULT_CREDENTIALS_FILE),
+		Bookroot: Envstr("BOOKROOT", BOOK_ROOT),
+		Storepath: Envstr("BOOKSTOREPATH", "abb.db"),
+		Enginepath: Envstr("ENGINEPATH", DEFAULT_ENGINE_PATH),
+		Numengines: Envint("NUMENGINES", 1),
+	}
+}
+
+// Builder owns the book store and the engines books are built with
+type Builder struct{
+	Config Config
+	Store BookStore
+	Engine *Engine
+	Pool *EnginePool
+}
+
+// Openstore opens the book store selected by the config
+func Openstore(cfg Config) (BookStore, error){
+	switch cfg.Store{
+	case "firestore":
+		_, err := os.Stat(cfg.Credentialsfile)
+		if err != nil{
+			return nil, fmt.Errorf("firestore credentials missing: %v", err)
+		}
+		return NewFirestoreStore(cfg.Credentialsfile, cfg.Bookroot)
+	case "local":
+		return NewBoltStore(cfg.Storepath)
+	case "memory":
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown book store %q", cfg.Store)
+}
+
+// Open opens the book store and starts the engine selected by the config,
+// the builder should be closed after use
+func Open(cfg Config) (*Builder, error){
+	if cfg.Numengines < 1{
+		cfg.Numengines = 1
+	}
+	bb := Builder{Config: cfg}
+	if cfg.Enginepath != ""{
+		err := bb.startengines(cfg.Enginepath)
+		if err != nil{
+			return nil, err
+		}
+	}
+	store, err := Openstore(cfg)
+	if err != nil{
+		bb.Close()
+		return nil, err
+	}
+	bb.Store = store
+	return &bb, nil
+}
+
+// startengines starts the engines of the builder with the engine executable,
+// replacing the running ones
+func (bb *Builder) startengines(enginepath string) error{
+	path, err := exec.LookPath(enginepath)
+	if err != nil{
+		return fmt.Errorf("engine binary missing: %v", err)
+	}
+	engines := []*Engine{}
+	for len(engines) < bb.Config.Numengines{
+		fmt.Println("--> starting engine", path, len(engines) + 1)
+		eng, err := NewEngine(path)
+		if err != nil{
+			NewEnginePool(engines).Close()
+			return fmt.Errorf("engine could not be started: %v", err)
+		}
+		engines = append(engines, eng)
+	}
+	fmt.Println("--> engines started", len(engines))
+	if bb.Pool != nil{
+		bb.Pool.Close()
+	}
+	bb.Config.Enginepath = enginepath
+	bb.Engine = engines[0]
+	bb.Pool = NewEnginePool(engines)
+	return nil
+}
+
+// Close stops the engines and closes the book store
+func (bb *Builder) Close() error{
+	if bb.Pool != nil{
+		bb.Pool.Close()
+		bb.Pool = nil
+		bb.Engine = nil
+	}
+	if bb.Store != nil{
+		err := bb.Store.Close()
+		bb.Store = nil
+		return err
+	}
+	return nil
+}
+
+// NewBook returns the book set by the environment, the stored book meta
+// and the book fields of the config, stored in the store and analyzed by
+// the engines of the builder, which are restarted if the book is built
+// with another engine
+func (bb *Builder) NewBook() (Book, error){
+	b, err := newbook(bb.Store, bb.Config.Bookfields)
+	if err != nil{
+		return b, err
+	}
+	if ( bb.Pool != nil ) && ( b.Enginepath != bb.Config.Enginepath ){
+		err = bb.startengines(b.Enginepath)
+		if err != nil{
+			return b, err
+		}
+	}
+	b.Engine = bb.Engine
+	b.Enginepool = bb.Pool
+	return b, nil
+}
+
+////////////////////////////////////////////////////////////////
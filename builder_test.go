@@ -0,0 +1,116 @@
+package abb
+
+import(
+	"strings"
+	"testing"
+)
+
+func TestOpenErrors(t *testing.T){
+	_, err := Open(Config{Store: "memory", Enginepath: "engines/nosuchengine"})
+	if err == nil{
+		t.Error("open with missing engine binary succeeded")
+	}
+	_, err = Open(Config{Store: "firestore", Credentialsfile: "nosuchdir/nosuchkey.json"})
+	if err == nil{
+		t.Error("open with missing credentials succeeded")
+	}
+	bb, err := Open(Config{Store: "memory"})
+	if err != nil{
+		t.Fatal(err)
+	}
+	if err := bb.Close(); err != nil{
+		t.Error(err)
+	}
+}
+
+func TestEngineconfig(t *testing.T){
+	t.Setenv("ENGINEPATH", "engines/otherengine")
+	t.Setenv("ENGINEHASH", "256")
+	t.Setenv("ENGINEMULTIPV", "5")
+	cfg := NewConfig()
+	if cfg.Enginepath != "engines/otherengine"{
+		t.Errorf("config engine path = %s", cfg.Enginepath)
+	}
+	cfg.Store = "memory"
+	cfg.Enginepath = ""
+	bb, err := Open(cfg)
+	if err != nil{
+		t.Fatal(err)
+	}
+	defer bb.Close()
+	b, err := bb.NewBook()
+	if err != nil{
+		t.Fatal(err)
+	}
+	want := Options{UCI_Variant: b.Variantkey, Hash: 256, Threads: DEFAULT_ENGINE_THREADS, MultiPV: 5}
+	if opt := b.Engineoptions(); opt != want{
+		t.Errorf("engine options = %+v, want %+v", opt, want)
+	}
+	meta := b.Serialize()
+	if ( meta["enginepath"] != "engines/otherengine" ) || ( meta["enginehash"] != "256" ) || ( meta["enginemultipv"] != "5" ){
+		t.Errorf("serialized engine settings = %v", meta)
+	}
+	t.Setenv("PVLENGTH", "-1")
+	if _, err := bb.NewBook(); err == nil{
+		t.Error("book with negative pv length accepted")
+	}
+	t.Setenv("PVLENGTH", "0")
+	for _, variantkey := range([]string{"horde", "crazyhouse", "antichess", "threeCheck"}){
+		t.Setenv("BOOKVARIANT", variantkey)
+		if _, err := bb.NewBook(); err == nil{
+			t.Errorf("book of unsupported variant %s accepted", variantkey)
+		}
+	}
+}
+
+func TestStoredbookmeta(t *testing.T){
+	t.Setenv("BOOKPOSKEY", POSKEY_POSID)
+	t.Setenv("BOOKMOD", "10")
+	bb, err := Open(Config{Store: "memory"})
+	if err != nil{
+		t.Fatal(err)
+	}
+	defer bb.Close()
+	b, err := bb.NewBook()
+	if err != nil{
+		t.Fatal(err)
+	}
+	if err := bb.Store.Updatefields(b.Id(), map[string]interface{}{"poskey": POSKEY_ZOBRIST, "mod": "7", "bookletencoding": BOOKLET_COMPRESSED}); err != nil{
+		t.Fatal(err)
+	}
+	b, err = bb.NewBook()
+	if err != nil{
+		t.Fatal(err)
+	}
+	if ( b.Poskey != POSKEY_ZOBRIST ) || ( b.Mod != 7 ) || ( b.Bookletencoding != BOOKLET_COMPRESSED ){
+		t.Errorf("poskey, mod, encoding = %s, %d, %s, want stored zobrist, 7, compressed", b.Poskey, b.Mod, b.Bookletencoding)
+	}
+	// stored engine settings are loaded unless the environment or the config sets them
+	err = bb.Store.Updatefields(b.Id(), map[string]interface{}{"enginepath": "engines/stored", "enginehash": "128", "enginethreads": "4", "enginemultipv": "7"})
+	if err != nil{
+		t.Fatal(err)
+	}
+	t.Setenv("ENGINEHASH", "256")
+	bb.Config.Bookfields = map[string]string{"enginemultipv": "3", "enginetimeout": "5000"}
+	b, err = bb.NewBook()
+	if err != nil{
+		t.Fatal(err)
+	}
+	if ( b.Enginepath != "engines/stored" ) || ( b.Enginehash != 256 ) || ( b.Enginethreads != 4 ) || ( b.Enginemultipv != 3 ) || ( b.Enginetimeout != 5000 ){
+		t.Errorf("engine settings = %s %d %d %d %d, want engines/stored 256 4 3 5000", b.Enginepath, b.Enginehash, b.Enginethreads, b.Enginemultipv, b.Enginetimeout)
+	}
+	for _, fields := range([]map[string]string{{"enginehash": "x"}, {"nosuchfield": "1"}, {"enginenodes": "-1", "analysismode": ANALYSIS_NODES}}){
+		bb.Config.Bookfields = fields
+		if _, err := bb.NewBook(); err == nil{
+			t.Errorf("book with settings %v accepted", fields)
+		}
+	}
+	// the engines are restarted with the stored engine of the book
+	bb.Config.Bookfields = nil
+	bb.Config.Enginepath = "engines/running"
+	bb.Config.Numengines = 1
+	bb.Pool = NewEnginePool([]*Engine{NewFakeEngine(t, testscript)})
+	if _, err := bb.NewBook(); ( err == nil ) || !strings.Contains(err.Error(), "engine binary missing"){
+		t.Errorf("book with another engine = %v, want a restart of the stored engine", err)
+	}
+}
@@ -0,0 +1,144 @@
+////////////////////////////////////////////////////////////////
+
+package abb
+
+////////////////////////////////////////////////////////////////
+
+import(
+	"fmt"
+	"sync"
+)
+
+////////////////////////////////////////////////////////////////
+
+// EnginePool hands out engines to goroutines, each engine is used
+// by one goroutine at a time
+type EnginePool struct{
+	engines []*Engine
+	free chan *Engine
+}
+
+// NewEnginePool returns a pool of the engines
+func NewEnginePool(engines []*Engine) *EnginePool{
+	pool := EnginePool{
+		engines: engines,
+		free: make(chan *Engine, len(engines)),
+	}
+	for _, eng := range(engines){
+		pool.free <- eng
+	}
+	return &pool
+}
+
+// Size returns the number of engines of the pool
+func (pool *EnginePool) Size() int{
+	return len(pool.engines)
+}
+
+// Get waits for a free engine and takes it from the pool
+func (pool *EnginePool) Get() *Engine{
+	return <- pool.free
+}
+
+// Put returns an engine taken by Get to the pool
+func (pool *EnginePool) Put(eng *Engine){
+	pool.free <- eng
+}
+
+// Close stops the engines of the pool
+func (pool *EnginePool) Close(){
+	for _, eng := range(pool.engines){
+		eng.Close()
+	}
+}
+
+////////////////////////////////////////////////////////////////
+
+// lockcache locks the position cache for writing and returns the unlock
+func (b Book) lockcache() func(){
+	if b.Poslock == nil{
+		return func(){}
+	}
+	b.Poslock.Lock()
+	return b.Poslock.Unlock
+}
+
+// rlockcache locks the position cache for reading and returns the unlock
+func (b Book) rlockcache() func(){
+	if b.Poslock == nil{
+		return func(){}
+	}
+	b.Poslock.RLock()
+	return b.Poslock.RUnlock
+}
+
+// Selectbatch selects up to n distinct positions for analysis
+func (b Book) Selectbatch(n int) []string{
+	fens := []string{}
+	selected := make(map[string]bool)
+	for widthbonus := 0; ( widthbonus < b.Analysisdepth ) && ( len(fens) < n ); widthbonus++{
+		for try := 0; ( try < 2 * n ) && ( len(fens) < n ); try++{
+			fen := b.Select(widthbonus)
+			if ( fen != "" ) && !selected[fen]{
+				selected[fen] = true
+				fens = append(fens, fen)
+			}
+		}
+	}
+	return fens
+}
+
+// Addbatch selects up to n distinct positions and analyzes them in parallel
+// with the engines of the pool, or the book engine if there is no pool,
+// returns the fens analyzed successfully, lastadd is only updated when
+// a position was stored
+func (b Book) Addbatch(n int) ([]string, error){
+	fmt.Println("add batch of", n, "to", b.Fullname())
+	pool := b.Enginepool
+	if pool == nil{
+		if b.Engine == nil{
+			return nil, fmt.Errorf("%s has no engine", b.Fullname())
+		}
+		pool = NewEnginePool([]*Engine{b.Engine})
+	}
+	fens := b.Selectbatch(n)
+	if len(fens) == 0{
+		fmt.Println("add batch failed")
+		return fens, nil
+	}
+	analyzed := make(chan string, len(fens))
+	var wg sync.WaitGroup
+	for _, fen := range(fens){
+		wg.Add(1)
+		go func(fen string){
+			defer wg.Done()
+			eng := pool.Get()
+			defer pool.Put(eng)
+			fmt.Println("analyzing", fen)
+			p, err := b.Analyzewith(eng, fen)
+			if err != nil{
+				fmt.Println("analysis failed", err)
+				return
+			}
+			fmt.Println("storing", p.Fen)
+			err = b.StorePosition(p)
+			if err != nil{
+				fmt.Println("storing failed", err)
+				return
+			}
+			analyzed <- fen
+		}(fen)
+	}
+	wg.Wait()
+	close(analyzed)
+	fens = []string{}
+	for fen := range(analyzed){
+		fens = append(fens, fen)
+	}
+	if len(fens) == 0{
+		return fens, nil
+	}
+	return fens, b.Updatefield("lastadd", Nowutcunixdate())
+}
+
+////////////////////////////////////////////////////////////////
@@ -0,0 +1,273 @@
+////////////////////////////////////////////////////////////////
+
+package abb
+
+////////////////////////////////////////////////////////////////
+
+import(
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////
+
+// Isfrontier tells whether the position has analyzed moves leading
+// to positions not yet in the cache
+func (b Book) Isfrontier(p BookPosition) bool{
+	defer b.rlockcache()()
+	return b.isfrontier(p)
+}
+
+// isfrontier is Isfrontier for callers holding the cache lock
+func (b Book) isfrontier(p BookPosition) bool{
+	for _, m := range(p.Moves){
+		newfen, err := b.Makealgebmove(m.Algeb, p.Fen)
+		if err != nil{
+			continue
+		}
+		if _, ok := b.getpos(newfen); !ok{
+			return true
+		}
+	}
+	return false
+}
+
+// Frontier returns the frontier positions of the cache sorted by fen
+func (b Book) Frontier() []BookPosition{
+	positions := []BookPosition{}
+	unlock := b.rlockcache()
+	for _, p := range(b.Poscache){
+		if b.isfrontier(p){
+			positions = append(positions, p)
+		}
+	}
+	unlock()
+	sort.Slice(positions, func(i, j int) bool{
+		return positions[i].Fen < positions[j].Fen
+	})
+	return positions
+}
+
+// Epd returns the position as an epd line with the best move, its
+// minimax eval in centipawns, the engine depth and the move clocks,
+// imported positions have no best move
+func (b Book) Epd(p BookPosition) (string, error){
+	board := NewBoard(b.Variantkey)
+	err := board.Setfromfen(p.Fen)
+	if err != nil{
+		return "", err
+	}
+	buff := strings.Join(strings.Split(board.Tofen(), " ")[0:4], " ")
+	mli := p.Getmovelist().Items
+	if ( len(mli) > 0 ) && !p.Isimported(){
+		buff += fmt.Sprintf(" bm %s; ce %d;", board.ToSAN(mli[0].Algeb), mli[0].Eval)
+	}
+	return buff + fmt.Sprintf(" acd %d; hmvc %d; fmvn %d;", p.Enginedepth, board.Halfmove, board.Fullmove), nil
+}
+
+// Exportepd writes the frontier positions in epd format
+func (b Book) Exportepd(w io.Writer) (int, error){
+	positions := b.Frontier()
+	for _, p := range(positions){
+		line, err := b.Epd(p)
+		if err != nil{
+			return 0, err
+		}
+		_, err = fmt.Fprintln(w, line)
+		if err != nil{
+			return 0, err
+		}
+	}
+	return len(positions), nil
+}
+
+// Exportepdfile writes the frontier positions to an epd file
+func (b Book) Exportepdfile(path string) error{
+	fmt.Println("exporting frontier of", b.Fullname(), "to", path)
+	f, err := os.Create(path)
+	if err != nil{
+		return err
+	}
+	w := bufio.NewWriter(f)
+	numpos, err := b.Exportepd(w)
+	if err == nil{
+		err = w.Flush()
+	}
+	closeerr := f.Close()
+	if err != nil{
+		return err
+	}
+	fmt.Println("exporting done", b.Fullname(), "positions", numpos)
+	return closeerr
+}
+
+////////////////////////////////////////////////////////////////
+
+// AnalysisMove is one analyzed move of a json lines analysis file,
+// the move is in uci or san
+type AnalysisMove struct{
+	Move string `json:"move"`
+	Score int `json:"score"`
+}
+
+// Analysis is one line of a json lines analysis file
+type Analysis struct{
+	Fen string `json:"fen"`
+	Depth int `json:"depth"`
+	Moves []AnalysisMove `json:"moves"`
+}
+
+// Analysisposition converts an analysis to a book position, the moves
+// are validated for variants with move generation, an analysis needs a
+// positive depth and moves, as a deeper analysis without moves would
+// replace the analyzed moves and a depth 0 analysis would pass for an
+// imported position
+func (b Book) Analysisposition(a Analysis) (BookPosition, error){
+	if a.Depth <= 0{
+		return BookPosition{}, fmt.Errorf("analysis of %s has depth %d, want a positive depth", a.Fen, a.Depth)
+	}
+	if len(a.Moves) == 0{
+		return BookPosition{}, fmt.Errorf("analysis of %s has no moves", a.Fen)
+	}
+	board := NewBoard(b.Variantkey)
+	err := board.Parsefen(a.Fen)
+	if err != nil{
+		return BookPosition{}, err
+	}
+	p := NewPosition(board.Tofen())
+	p.Enginedepth = a.Depth
+	for _, am := range(a.Moves){
+		algeb := am.Move
+		if board.Checkalgeb(algeb) != nil{
+			algeb, err = board.ParseSAN(am.Move)
+			if err != nil{
+				return p, err
+			}
+		}
+		if Hasmovegen(b.Variantkey) && !board.Islegal(algeb){
+			return p, fmt.Errorf("illegal move %s", am.Move)
+		}
+		p.Moves = append(p.Moves, BookMove{algeb, am.Score, am.Score, INFINITE_MINIMAX_DEPTH, 0, [3]int{}, nil})
+	}
+	return p, nil
+}
+
+// Parseepd parses an epd line with bm, ce, acd, hmvc and fmvn opcodes,
+// all best moves get the centipawn eval
+func Parseepd(line string) (Analysis, error){
+	fields := strings.Fields(line)
+	if len(fields) < 4{
+		return Analysis{}, fmt.Errorf("invalid epd %q", line)
+	}
+	a := Analysis{Fen: strings.Join(fields[0:4], " ")}
+	bms := []string{}
+	ce := 0
+	hmvc, fmvn := "", ""
+	for _, op := range(strings.Split(strings.Join(fields[4:], " "), ";")){
+		operands := strings.Fields(op)
+		if len(operands) < 2{
+			continue
+		}
+		var err error
+		switch operands[0]{
+		case "bm":
+			bms = operands[1:]
+		case "ce":
+			ce, err = strconv.Atoi(operands[1])
+		case "acd":
+			a.Depth, err = strconv.Atoi(operands[1])
+		case "hmvc":
+			hmvc = operands[1]
+		case "fmvn":
+			fmvn = operands[1]
+		}
+		if err != nil{
+			return a, fmt.Errorf("invalid epd opcode %q", op)
+		}
+	}
+	for _, bm := range(bms){
+		a.Moves = append(a.Moves, AnalysisMove{bm, ce})
+	}
+	// the clocks are kept when both are given, the fen parser validates them
+	if ( hmvc != "" ) && ( fmvn != "" ){
+		a.Fen += " " + hmvc + " " + fmvn
+	}
+	return a, nil
+}
+
+// Mergeposition stores the position unless the cache has an analysis
+// of at least the same engine depth, tells whether it was stored
+func (b Book) Mergeposition(p BookPosition) (bool, error){
+	key, err := b.Poskeyof(p.Fen)
+	if err != nil{
+		return false, err
+	}
+	defer b.lockcache()()
+	oldp, ok := b.Poscache[key]
+	if ok && ( oldp.Enginedepth >= p.Enginedepth ){
+		return false, nil
+	}
+	b.Poscache[key] = p
+	return true, nil
+}
+
+// Importanalysis merges the analyzed positions of an epd or json lines
+// reader into the cache, returns the number of positions stored
+func (b Book) Importanalysis(r io.Reader) (int, error){
+	scanner := bufio.NewScanner(r)
+	numpos := 0
+	linenum := 0
+	for scanner.Scan(){
+		linenum++
+		line := strings.TrimSpace(scanner.Text())
+		if ( line == "" ) || strings.HasPrefix(line, "#"){
+			continue
+		}
+		var a Analysis
+		var err error
+		if strings.HasPrefix(line, "{"){
+			err = json.Unmarshal([]byte(line), &a)
+		}else{
+			a, err = Parseepd(line)
+		}
+		if err != nil{
+			return numpos, fmt.Errorf("line %d: %v", linenum, err)
+		}
+		p, err := b.Analysisposition(a)
+		if err != nil{
+			return numpos, fmt.Errorf("line %d: %v", linenum, err)
+		}
+		stored, err := b.Mergeposition(p)
+		if err != nil{
+			return numpos, fmt.Errorf("line %d: %v", linenum, err)
+		}
+		if stored{
+			numpos++
+		}
+	}
+	return numpos, scanner.Err()
+}
+
+// Importanalysisfile merges an epd or json lines analysis file into the cache
+func (b Book) Importanalysisfile(path string) error{
+	fmt.Println("importing analysis", path, "into", b.Fullname())
+	f, err := os.Open(path)
+	if err != nil{
+		return err
+	}
+	defer f.Close()
+	numpos, err := b.Importanalysis(f)
+	if err != nil{
+		return err
+	}
+	fmt.Println("importing done", b.Fullname(), "positions", numpos)
+	return nil
+}
+
+////////////////////////////////////////////////////////////////
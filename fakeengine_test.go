@@ -0,0 +1,115 @@
+////////////////////////////////////////////////////////////////
+
+package abb
+
+////////////////////////////////////////////////////////////////
+
+import(
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+////////////////////////////////////////////////////////////////
+
+// FakeScript maps fens to the lines a fake engine prints when it is
+// told to go in that position, positions are matched by posid so the
+// move clocks of the fen are ignored
+type FakeScript map[string][]string
+
+func (fs FakeScript) lines(fen string) []string{
+	posid := Fen2posid(fen)
+	for scriptfen, lines := range(fs){
+		if Fen2posid(scriptfen) == posid{
+			return lines
+		}
+	}
+	return nil
+}
+
+// NewFakeEngine returns an Engine answered by a goroutine instead of an
+// engine process, failing the test if the handshake fails, on go it prints the scripted lines for the current
+// position followed by a bestmove line if the script has none,
+// unscripted positions are answered with bestmove (none), a scripted
+// line crash n makes the first n starts of the engine crash there,
+// a scripted line wait makes the engine wait for stop, a scripted
+// line hang makes it ignore everything until its input is closed
+func NewFakeEngine(t testing.TB, script FakeScript) *Engine{
+	t.Helper()
+	starts := 0
+	eng, err := newPipeEngine(func() (io.WriteCloser, io.Reader){
+		starts++
+		enginein, enginestdin := io.Pipe()
+		enginestdout, engineout := io.Pipe()
+		go runfakeengine(script, starts, enginein, engineout)
+		return enginestdin, enginestdout
+	})
+	if err != nil{
+		t.Fatalf("fake engine handshake : %v", err)
+	}
+	return eng
+}
+
+// FAKE_ENGINE_UCI is the answer of the fake engine to uci
+var FAKE_ENGINE_UCI = []string{
+	"id name Fake Engine",
+	"id author abb",
+	"option name UCI_Variant type combo default chess var chess var atomic",
+	"option name Hash type spin default 16 min 1 max 1024",
+	"option name Threads type spin default 1 min 1 max 8",
+	"option name MultiPV type spin default 1 min 1 max 500",
+	"option name Ponder type check default false",
+	"uciok",
+}
+
+func runfakeengine(script FakeScript, start int, in io.ReadCloser, out io.WriteCloser){
+	defer out.Close()
+	defer in.Close()
+	scanner := bufio.NewScanner(in)
+	fen := START_FEN
+	for scanner.Scan(){
+		line := scanner.Text()
+		if line == "uci"{
+			for _, uciline := range(FAKE_ENGINE_UCI){
+				fmt.Fprintln(out, uciline)
+			}
+		}else if line == "isready"{
+			fmt.Fprintln(out, "readyok")
+		}else if strings.HasPrefix(line, "position fen "){
+			fen = strings.TrimPrefix(line, "position fen ")
+		}else if strings.HasPrefix(line, "go"){
+			hasbestmove := false
+			for _, scriptline := range(script.lines(fen)){
+				if strings.HasPrefix(scriptline, "crash ") && ( start <= str2int(strings.TrimPrefix(scriptline, "crash "), 0) ){
+					return
+				}
+				if strings.HasPrefix(scriptline, "crash "){
+					continue
+				}
+				if scriptline == "wait"{
+					for scanner.Scan() && ( scanner.Text() != "stop" ){
+					}
+					continue
+				}
+				if scriptline == "hang"{
+					for scanner.Scan(){
+					}
+					return
+				}
+				if strings.HasPrefix(scriptline, "bestmove"){
+					hasbestmove = true
+				}
+				fmt.Fprintln(out, scriptline)
+			}
+			if !hasbestmove{
+				fmt.Fprintln(out, "bestmove (none)")
+			}
+		}else if line == "quit"{
+			return
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////
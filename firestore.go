@@ -5,158 +5,160 @@ package abb
 ////////////////////////////////////////////////////////////////
 
 import (
-	"fmt"	
-	"context"		
-	"time"
+	"fmt"
+	"context"
 
 	firebase "firebase.google.com/go"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 ////////////////////////////////////////////////////////////////
 
-var ctx context.Context
-var client *firestore.Client
-var bookcoll *firestore.CollectionRef
-
-////////////////////////////////////////////////////////////////
-
 const BOOK_ROOT = "books2"
 
 ////////////////////////////////////////////////////////////////
 
-func init(){
+// FirestoreStore is a BookStore backed by a Firestore collection,
+// books are documents of the collection, booklets are documents
+// of the booklets subcollection of the book document
+type FirestoreStore struct{
+	ctx context.Context
+	client *firestore.Client
+	bookcoll *firestore.CollectionRef
+}
+
+// NewFirestoreStore connects to Firestore using the given service account
+// credentials file and returns a store rooted at the given collection
+func NewFirestoreStore(credentialsfile string, root string) (*FirestoreStore, error){
 	fmt.Println("--> initializing firestore")
-	ctx = context.Background()
-	opt := option.WithCredentialsFile("firebase/fbsacckey.json")
+	ctx := context.Background()
+	opt := option.WithCredentialsFile(credentialsfile)
 	app, err := firebase.NewApp(ctx, nil, opt)
 	if err != nil{
-		fmt.Println("Fatal. Firestore app could not be initialized.")
-		return
+		return nil, fmt.Errorf("firestore app could not be initialized: %v", err)
 	}
-	client, err = app.Firestore(ctx)
+	client, err := app.Firestore(ctx)
 	if err != nil{
-		fmt.Println("Fatal. Firestore client could not be created.")
-		return
+		return nil, fmt.Errorf("firestore client could not be created: %v", err)
 	}
-	bookcoll = client.Collection(BOOK_ROOT)
-	testbook := bookcoll.Doc("test")
-	value, err := testbook.Set(ctx, map[string]interface{}{
-		"meta": "test",
-	})
-	fmt.Println("testbook", value, err)
 	fmt.Println("--> firestore initialized")
+	return &FirestoreStore{
+		ctx: ctx,
+		client: client,
+		bookcoll: client.Collection(root),
+	}, nil
+}
+
+func (s *FirestoreStore) booklets(id string) *firestore.CollectionRef{
+	return s.bookcoll.Doc(id).Collection("booklets")
 }
 
-func Listbooks(){
-	fmt.Printf("list of books [ root : %s ]\n", BOOK_ROOT)
-	iter := bookcoll.Documents(ctx)
+func (s *FirestoreStore) Listbooks() ([]string, error){
+	ids := []string{}
+	iter := s.bookcoll.Documents(s.ctx)
 	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
 			break
 		}
-		fmt.Println("*",doc.Ref.ID)
+		if err != nil{
+			return nil, err
+		}
+		ids = append(ids, doc.Ref.ID)
 	}
+	return ids, nil
 }
 
-func StoreBook(b Book){	
-	bd := bookcoll.Doc(b.Id())
-	b.Booklets = bd.Collection("booklets")
-	bd.Set(ctx, b.Serialize())
+func (s *FirestoreStore) Storebook(id string, meta map[string]interface{}) error{
+	data := make(map[string]interface{})
+	for key, value := range(meta){
+		data[key] = value
+	}
+	data["booklets"] = s.booklets(id)
+	_, err := s.bookcoll.Doc(id).Set(s.ctx, data)
+	return err
 }
 
-func Updatebookfield(b Book, key string, value string){
-	bookcoll.Doc(b.Id()).Update(ctx, []firestore.Update{
-		{Path: key, Value: value},		
-	})
+func (s *FirestoreStore) Loadbook(id string) (map[string]interface{}, error){
+	doc, err := s.bookcoll.Doc(id).Get(s.ctx)
+	if status.Code(err) == codes.NotFound{
+		return nil, nil
+	}
+	if err != nil{
+		return nil, err
+	}
+	meta := doc.Data()
+	// the booklets reference is set by Storebook
+	delete(meta, "booklets")
+	return meta, nil
 }
 
-func Synccache(b *Book){
-	start := time.Now()
-	fmt.Println(SEP)
-	fmt.Println("syncing cache", b.Fullname())	
-	fmt.Println(SEP)
-	b.Poscache = make(map[string]BookPosition)
-	numpos := 0
-	grandtotalblobsize := 0
-	maxnumbpos := 0
-	maxtotalblobsize := 0
-	iter := bookcoll.Doc(b.Id()).Collection("booklets").Documents(ctx)
+func (s *FirestoreStore) Updatefields(id string, fields map[string]interface{}) error{
+	updates := []firestore.Update{}
+	for key, value := range(fields){
+		updates = append(updates, firestore.Update{Path: key, Value: value})
+	}
+	_, err := s.bookcoll.Doc(id).Update(s.ctx, updates)
+	return err
+}
+
+func (s *FirestoreStore) Loadbooklets(id string) ([]Booklet, error){
+	booklets := []Booklet{}
+	iter := s.booklets(id).Documents(s.ctx)
 	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
 			break
-		}		
-		positions := doc.Data()["positions"].(map[string]interface{})
-		numbpos := 0
-		totalblobsize := 0
-		for _, posdoc := range(positions){			
-			blob := posdoc.(map[string]interface{})["blob"].(string)
-			p := BookPositionFromBlob(blob)
-			b.Poscache[p.Posid()] = p
-			numpos++
-			numbpos++
-			totalblobsize += len(blob)
-			grandtotalblobsize += len(blob)
-		}		
-		fmt.Println(doc.Ref.ID, numbpos, totalblobsize)
-		if numbpos > maxnumbpos{
-			maxnumbpos = numbpos			
 		}
-		if totalblobsize > maxtotalblobsize{
-			maxtotalblobsize = totalblobsize
+		if err != nil{
+			return nil, err
+		}
+		booklet := Booklet{
+			Id: doc.Ref.ID,
+			Positions: make(map[string]string),
+		}
+		data := doc.Data()
+		if packed, ok := data[BOOKLET_PACKED_KEY].([]byte); ok{
+			booklet.Packed = packed
+		}
+		positions, _ := data["positions"].(map[string]interface{})
+		for posid, posdoc := range(positions){
+			blob, _ := posdoc.(map[string]interface{})["blob"].(string)
+			booklet.Positions[posid] = blob
 		}
+		booklets = append(booklets, booklet)
 	}
-	elapsed := time.Since(start)
-	fmt.Println("syncing cache done", b.Fullname(), "positions", numpos, "took", elapsed, "average blob size", grandtotalblobsize / (numpos+1), "max positions per booklet", maxnumbpos, "max total blobsize", maxtotalblobsize)	
-	bookcoll.Doc(b.Id()).Update(ctx, []firestore.Update{
-		{Path: "numpos", Value: numpos},
-		{Path: "maxnumbpos", Value: maxnumbpos},
-		{Path: "maxtotalblobsize", Value: maxtotalblobsize},
-		{Path: "lastsync", Value: Nowutcunixdate()},
-	})
+	return booklets, nil
 }
 
-func Uploadcache(b Book){
-	start := time.Now()
-	fmt.Println(SEP)
-	fmt.Println("uploading cache", b.Fullname())	
-	fmt.Println(SEP)
-	numpos := 0
-	maxblobsize := 0
-	booklets := make(map[string]map[string]interface{})
-	for _, p := range(b.Poscache){		
-		bid := b.Bookletid(p.Fen)
-		booklet, ok := booklets[bid]
-		if !ok{
-			booklet = map[string]interface{}{
-				"positions": make(map[string]interface{}),
+func (s *FirestoreStore) Savebooklets(id string, booklets []Booklet) error{
+	for _, booklet := range(booklets){
+		positions := make(map[string]interface{})
+		for posid, blob := range(booklet.Positions){
+			positions[posid] = map[string]interface{}{
+				"blob": blob,
 			}
-			booklets[bid] = booklet
 		}
-		booklet, _ = booklets[bid]		
-		blob := p.Serialize()
-		booklet["positions"].(map[string]interface{})[p.Posid()] = blob
-		blobsize := len(blob["blob"].(string))
-		if blobsize > maxblobsize{
-			maxblobsize = blobsize
+		data := map[string]interface{}{
+			"positions": positions,
+		}
+		if booklet.Packed != nil{
+			data[BOOKLET_PACKED_KEY] = booklet.Packed
+		}
+		_, err := s.booklets(id).Doc(booklet.Id).Set(s.ctx, data)
+		if err != nil{
+			return err
 		}
-		numpos++
-	}		
-	for bookletid, booklet := range(booklets){
-		fmt.Println("uploading", bookletid, b.Fullname())		
-		bookcoll.Doc(b.Id()).Collection("booklets").Doc(bookletid).Set(ctx, booklet)
 	}
-	elapsed := time.Since(start)
-	fmt.Println("uploading cache done", b.Fullname(), "positions", numpos, "max blobsize", maxblobsize, "took", elapsed)
-	bookcoll.Doc(b.Id()).Update(ctx, []firestore.Update{
-		{Path: "numpos", Value: numpos},		
-		{Path: "maxblobsize", Value: maxblobsize},
-		{Path: "lastupload", Value: Nowutcunixdate()},
-	})
+	return nil
 }
 
-////////////////////////////////////////////////////////////////
\ No newline at end of file
+func (s *FirestoreStore) Close() error{
+	return s.client.Close()
+}
+
+////////////////////////////////////////////////////////////////
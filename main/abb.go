@@ -1,18 +1,329 @@
 package main
 
 import(
+	"flag"
 	"fmt"	
+	"os"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/handywebprojects/abb"
 )
 
+func push(){
+	cfg := abb.NewConfig()
+	cfg.Store = "local"
+	local, err := abb.Openstore(cfg)
+	if err != nil{
+		fmt.Println("Fatal.", err)
+		return
+	}
+	defer local.Close()
+	cfg.Store = "firestore"
+	remote, err := abb.Openstore(cfg)
+	if err != nil{
+		fmt.Println("Fatal.", err)
+		return
+	}
+	defer remote.Close()
+	b, err := abb.NewBook(local)
+	if err != nil{
+		fmt.Println("Fatal.", err)
+		return
+	}
+	err = abb.Copybook(b, remote)
+	if err != nil{
+		fmt.Println("Fatal. Book could not be pushed.", err)
+	}
+}
+
+// perft prints the perft divide and total of a fen,
+// usage : abb perft <fen> <depth> [variant]
+func perft(args []string){
+	if len(args) < 2{
+		fmt.Println("usage : abb perft <fen> <depth> [variant]")
+		return
+	}
+	depth, err := strconv.Atoi(args[1])
+	if err != nil{
+		fmt.Println("Fatal. Invalid depth.", args[1])
+		return
+	}
+	variantkey := abb.Envstr("BOOKVARIANT", "atomic")
+	if len(args) > 2{
+		variantkey = args[2]
+	}
+	err = abb.Checkvariant(variantkey)
+	if err != nil{
+		fmt.Println("Fatal.", err)
+		return
+	}
+	board := abb.NewBoard(variantkey)
+	err = board.Parsefen(args[0])
+	if err != nil{
+		fmt.Println("Fatal.", err)
+		return
+	}
+	start := time.Now()
+	divide := board.Perftdivide(depth)
+	algebs := []string{}
+	total := 0
+	for algeb, nodes := range(divide){
+		algebs = append(algebs, algeb)
+		total += nodes
+	}
+	sort.Strings(algebs)
+	for _, algeb := range(algebs){
+		fmt.Println(algeb, divide[algeb])
+	}
+	if depth <= 0{
+		total = 1
+	}
+	fmt.Println("perft", depth, variantkey, "nodes", total, "took", time.Since(start))
+}
+
+// withbook opens the store without engine, syncs the cache of the
+// book set by the environment and calls f with the book
+func withbook(f func(b *abb.Book) error) error{
+	cfg := abb.NewConfig()
+	cfg.Enginepath = ""
+	bb, err := abb.Open(cfg)
+	if err != nil{
+		return err
+	}
+	defer bb.Close()
+	b, err := bb.NewBook()
+	if err != nil{
+		return err
+	}
+	err = b.Synccache()
+	if err != nil{
+		return err
+	}
+	return f(&b)
+}
+
+// migrate rekeys the stored booklets of the book with another position key,
+// usage : abb migrate <posid|zobrist>
+func migrate(args []string){
+	if len(args) < 1{
+		fmt.Println("usage : abb migrate <posid|zobrist>")
+		return
+	}
+	err := withbook(func(b *abb.Book) error{
+		err := b.Migrateposkeys(args[0])
+		if err != nil{
+			return err
+		}
+		err = b.Store()
+		if err != nil{
+			return err
+		}
+		return b.Uploadcache()
+	})
+	if err != nil{
+		fmt.Println("Fatal. Book could not be migrated.", err)
+	}
+}
+
+// encoding reencodes the stored booklets of the book,
+// usage : abb encoding <text|binary|compressed>
+func encoding(args []string){
+	if len(args) < 1{
+		fmt.Println("usage : abb encoding <text|binary|compressed>")
+		return
+	}
+	err := withbook(func(b *abb.Book) error{
+		err := b.Reencode(args[0])
+		if err != nil{
+			return err
+		}
+		err = b.Store()
+		if err != nil{
+			return err
+		}
+		return b.Uploadcache()
+	})
+	if err != nil{
+		fmt.Println("Fatal. Book could not be reencoded.", err)
+	}
+}
+
+// polyglot exports the book to a Polyglot .bin file,
+// usage : abb polyglot <file>
+func polyglot(args []string){
+	if len(args) < 1{
+		fmt.Println("usage : abb polyglot <file>")
+		return
+	}
+	err := withbook(func(b *abb.Book) error{
+		return b.Exportpolyglotfile(args[0])
+	})
+	if err != nil{
+		fmt.Println("Fatal. Book could not be exported.", err)
+	}
+}
+
+// importpolyglot seeds the book with a Polyglot .bin file,
+// usage : abb importpolyglot <file>
+func importpolyglot(args []string){
+	if len(args) < 1{
+		fmt.Println("usage : abb importpolyglot <file>")
+		return
+	}
+	err := withbook(func(b *abb.Book) error{
+		err := b.Importpolyglotfile(args[0])
+		if err != nil{
+			return err
+		}
+		err = b.Store()
+		if err != nil{
+			return err
+		}
+		return b.Uploadcache()
+	})
+	if err != nil{
+		fmt.Println("Fatal. Book could not be imported.", err)
+	}
+}
+
+// pgn exports the book tree with evals to a pgn file,
+// usage : abb pgn <file>
+func pgn(args []string){
+	if len(args) < 1{
+		fmt.Println("usage : abb pgn <file>")
+		return
+	}
+	err := withbook(func(b *abb.Book) error{
+		return b.Exportpgnfile(args[0])
+	})
+	if err != nil{
+		fmt.Println("Fatal. Book could not be exported.", err)
+	}
+}
+
+// epd exports the frontier positions of the book to an epd file,
+// usage : abb epd <file>
+func epd(args []string){
+	if len(args) < 1{
+		fmt.Println("usage : abb epd <file>")
+		return
+	}
+	err := withbook(func(b *abb.Book) error{
+		return b.Exportepdfile(args[0])
+	})
+	if err != nil{
+		fmt.Println("Fatal. Book could not be exported.", err)
+	}
+}
+
+// importanalysis merges an epd or json lines analysis file into the book,
+// usage : abb importanalysis <file>
+func importanalysis(args []string){
+	if len(args) < 1{
+		fmt.Println("usage : abb importanalysis <file>")
+		return
+	}
+	err := withbook(func(b *abb.Book) error{
+		err := b.Importanalysisfile(args[0])
+		if err != nil{
+			return err
+		}
+		return b.Uploadcache()
+	})
+	if err != nil{
+		fmt.Println("Fatal. Analysis could not be imported.", err)
+	}
+}
+
 func main(){		
 	fmt.Println("abb - Auto Book Builder")		
-	b := abb.NewBook()		
-	b.Store()	
-	abb.Listbooks()
-	b.Synccache()			
+	if len(os.Args) > 1{
+		switch os.Args[1]{
+		case "push":
+			push()
+			return
+		case "perft":
+			perft(os.Args[2:])
+			return
+		case "migrate":
+			migrate(os.Args[2:])
+			return
+		case "encoding":
+			encoding(os.Args[2:])
+			return
+		case "polyglot":
+			polyglot(os.Args[2:])
+			return
+		case "pgn":
+			pgn(os.Args[2:])
+			return
+		case "epd":
+			epd(os.Args[2:])
+			return
+		case "importanalysis":
+			importanalysis(os.Args[2:])
+			return
+		case "importpolyglot":
+			importpolyglot(os.Args[2:])
+			return
+		}
+	}
+	// engine flags override the environment
+	cfg := abb.NewConfig()
+	flags := flag.NewFlagSet("abb", flag.ContinueOnError)
+	flags.StringVar(&cfg.Enginepath, "engine", cfg.Enginepath, "engine executable")
+	flags.IntVar(&cfg.Numengines, "engines", cfg.Numengines, "number of engines analyzing in parallel")
+	flags.Int("hash", abb.Envint("ENGINEHASH", abb.DEFAULT_ENGINE_HASH), "engine hash size in MB")
+	flags.Int("threads", abb.Envint("ENGINETHREADS", abb.DEFAULT_ENGINE_THREADS), "engine threads")
+	flags.Int("multipv", abb.Envint("ENGINEMULTIPV", abb.DEFAULT_ENGINE_MULTIPV), "number of moves analyzed per position")
+	flags.String("mode", abb.Envstr("ANALYSISMODE", abb.ANALYSIS_DEPTH), "analysis mode : depth, nodes or movetime")
+	flags.Int("nodes", abb.Envint("ENGINENODES", 0), "nodes per analysis in nodes mode")
+	flags.Int("movetime", abb.Envint("ENGINEMOVETIME", 0), "milliseconds per analysis in movetime mode")
+	flags.Int("timeout", abb.Envint("ENGINETIMEOUT", 0), "milliseconds after which an analysis is stopped, 0 for none")
+	if err := flags.Parse(os.Args[1:]); err != nil{
+		return
+	}
+	// the flags given override the settings of the book, which checks them
+	flagfields := map[string]string{
+		"engine": "enginepath",
+		"hash": "enginehash",
+		"threads": "enginethreads",
+		"multipv": "enginemultipv",
+		"mode": "analysismode",
+		"nodes": "enginenodes",
+		"movetime": "enginemovetime",
+		"timeout": "enginetimeout",
+	}
+	cfg.Bookfields = map[string]string{}
+	flags.Visit(func(f *flag.Flag){
+		if field, ok := flagfields[f.Name]; ok{
+			cfg.Bookfields[field] = f.Value.String()
+		}
+	})
+	bb, err := abb.Open(cfg)
+	if err != nil{
+		fmt.Println("Fatal.", err)
+		return
+	}
+	defer bb.Close()
+	b, err := bb.NewBook()
+	if err != nil{
+		fmt.Println("Fatal.", err)
+		return
+	}
+	err = b.Store()
+	if err != nil{
+		fmt.Println("Fatal. Book could not be stored.", err)
+		return
+	}
+	abb.Listbooks(bb.Store)
+	err = b.Synccache()
+	if err != nil{
+		fmt.Println("Fatal. Cache could not be synced.", err)
+		return
+	}
 	//b.Uploadcache()
 	//return
 	time.Sleep(3 * time.Second)
@@ -28,7 +339,11 @@ func main(){
 			b.Updatefield("buildinfo", buildinfo)
 			fmt.Println(abb.SEP)
 			time.Sleep(1 * time.Second)
-			b.Addone()
+			_, err = b.Addbatch(bb.Config.Numengines)
+			if err != nil{
+				fmt.Println("Fatal.", err)
+				return
+			}
 			if ( j != 0 ) && ( ( j % b.Minimaxafter ) == 0 ){
 				b.Minimaxout()
 			}
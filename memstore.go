@@ -0,0 +1,127 @@
+////////////////////////////////////////////////////////////////
+
+package abb
+
+////////////////////////////////////////////////////////////////
+
+import(
+	"sort"
+	"sync"
+)
+
+////////////////////////////////////////////////////////////////
+
+// MemoryStore is a BookStore kept in memory, it is meant for tests
+// and dry runs, nothing is persisted
+type MemoryStore struct{
+	mu sync.Mutex
+	books map[string]map[string]interface{}
+	booklets map[string]map[string]Booklet
+}
+
+func NewMemoryStore() *MemoryStore{
+	return &MemoryStore{
+		books: make(map[string]map[string]interface{}),
+		booklets: make(map[string]map[string]Booklet),
+	}
+}
+
+func copybooklet(booklet Booklet) Booklet{
+	positions := make(map[string]string)
+	for posid, blob := range(booklet.Positions){
+		positions[posid] = blob
+	}
+	var packed []byte
+	if booklet.Packed != nil{
+		packed = append([]byte{}, booklet.Packed...)
+	}
+	return Booklet{booklet.Id, positions, packed}
+}
+
+// Getbook returns a copy of the stored metadata of a book
+func (s *MemoryStore) Getbook(id string) (map[string]interface{}, bool){
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	meta, ok := s.books[id]
+	if !ok{
+		return nil, false
+	}
+	metacopy := make(map[string]interface{})
+	for key, value := range(meta){
+		metacopy[key] = value
+	}
+	return metacopy, true
+}
+
+func (s *MemoryStore) Listbooks() ([]string, error){
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := []string{}
+	for id := range(s.books){
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (s *MemoryStore) Storebook(id string, meta map[string]interface{}) error{
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.books[id] = make(map[string]interface{})
+	for key, value := range(meta){
+		s.books[id][key] = value
+	}
+	return nil
+}
+
+func (s *MemoryStore) Loadbook(id string) (map[string]interface{}, error){
+	meta, _ := s.Getbook(id)
+	return meta, nil
+}
+
+func (s *MemoryStore) Updatefields(id string, fields map[string]interface{}) error{
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	meta, ok := s.books[id]
+	if !ok{
+		meta = make(map[string]interface{})
+		s.books[id] = meta
+	}
+	for key, value := range(fields){
+		meta[key] = value
+	}
+	return nil
+}
+
+func (s *MemoryStore) Loadbooklets(id string) ([]Booklet, error){
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	booklets := []Booklet{}
+	for _, booklet := range(s.booklets[id]){
+		booklets = append(booklets, copybooklet(booklet))
+	}
+	sort.Slice(booklets, func(i, j int) bool{
+		return booklets[i].Id < booklets[j].Id
+	})
+	return booklets, nil
+}
+
+func (s *MemoryStore) Savebooklets(id string, booklets []Booklet) error{
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bookbooklets, ok := s.booklets[id]
+	if !ok{
+		bookbooklets = make(map[string]Booklet)
+		s.booklets[id] = bookbooklets
+	}
+	for _, booklet := range(booklets){
+		bookbooklets[booklet.Id] = copybooklet(booklet)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Close() error{
+	return nil
+}
+
+////////////////////////////////////////////////////////////////
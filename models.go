@@ -5,18 +5,30 @@ package abb
 ////////////////////////////////////////////////////////////////
 
 import(
+	"context"
 	"fmt"
 	"strconv"
-	"strings"
 	"sort"
-
-	"cloud.google.com/go/firestore"
+	"sync"
+	"time"
 )
 
 ////////////////////////////////////////////////////////////////
 
 const INFINITE_MINIMAX_DEPTH = 1000
 
+// number of moves of the engine pv stored after each book move
+const DEFAULT_PV_LENGTH = 8
+
+// position keys of the position cache and the booklets
+const POSKEY_POSID = "posid"
+const POSKEY_ZOBRIST = "zobrist"
+
+// analysis modes, searches are limited by depth, nodes or time
+const ANALYSIS_DEPTH = "depth"
+const ANALYSIS_NODES = "nodes"
+const ANALYSIS_MOVETIME = "movetime"
+
 ////////////////////////////////////////////////////////////////
 
 type BookMove struct{
@@ -25,21 +37,18 @@ type BookMove struct{
 	Eval int
 	Minimaxdepth int
 	Haspv int
+	Wdl [3]int // win, draw and loss permille of the engine, zero if not reported
+	Pv []string // engine continuation after the move, bounded by the pv length of the book
 }
 
-func (m BookMove) Serialize() string{
-	return fmt.Sprintf("%s;%d;%d;%d;%d", m.Algeb, m.Score, m.Eval, m.Minimaxdepth, m.Haspv)
+// Line returns the move followed by its engine continuation
+func (m BookMove) Line() []string{
+	return append([]string{m.Algeb}, m.Pv...)
 }
 
-func BookMoveFromBlob(blob string) BookMove{
-	parts := strings.Split(blob, ";")
-	return BookMove{
-		Algeb: parts[0],
-		Score: str2int(parts[1], 0),
-		Eval: str2int(parts[2], 0),
-		Minimaxdepth: str2int(parts[3], INFINITE_MINIMAX_DEPTH),
-		Haspv: str2int(parts[1], 0),
-	}
+// Haswdl tells whether the engine reported win, draw and loss probabilities
+func (m BookMove) Haswdl() bool{
+	return m.Wdl != [3]int{}
 }
 
 type Movelist struct{
@@ -68,6 +77,13 @@ func (p BookPosition) Posid() string{
 	return Fen2posid(p.Fen)
 }
 
+// Isimported tells whether the position was imported with moves but not analyzed
+// yet, the scores of its moves are on the Polyglot weight scale rather than engine
+// evals, so the position is selected for analysis and left out of the minimax
+func (p BookPosition) Isimported() bool{
+	return ( p.Enginedepth == 0 ) && ( len(p.Moves) > 0 )
+}
+
 func (p BookPosition) Getmovelist() Movelist{
 	movelist := make([]BookMove, 0)
 	for _, move := range(p.Moves){
@@ -79,17 +95,6 @@ func (p BookPosition) Getmovelist() Movelist{
 	return ml
 }
 
-func (p BookPosition) Serialize() map[string]interface{}{
-	strs := []string{}
-	for _, m := range(p.Moves){
-		strs = append(strs, m.Serialize())
-	}
-	blob := fmt.Sprintf("%s;;%d;;%s", p.Fen, p.Enginedepth, strings.Join(strs, "|"))
-	return map[string]interface{}{
-		"blob": blob,
-	}
-}
-
 func NewPosition(fen string) BookPosition{
 	return BookPosition{
 		Fen: fen,
@@ -97,21 +102,6 @@ func NewPosition(fen string) BookPosition{
 	}
 }
 
-func BookPositionFromBlob(blob string) BookPosition{
-	parts := strings.Split(blob, ";;")
-	moveparts := strings.Split(parts[2], "|")
-	p := BookPosition{
-		Fen: parts[0],
-		Enginedepth: str2int(parts[1], 0),
-		Moves: make([]BookMove, 0),
-	}
-	for _, moveblob := range(moveparts){
-		m := BookMoveFromBlob(moveblob)
-		p.Moves = append(p.Moves, m)
-	}
-	return p
-}
-
 type Book struct{
 	Name string
 	Variantkey string
@@ -124,20 +114,35 @@ type Book struct{
 	Minimaxafter int
 	Cutoff int
 	Widths []int	
-	Booklets *firestore.CollectionRef
+	Poskey string
+	Enginepath string
+	Enginehash int
+	Enginethreads int
+	Enginemultipv int
+	Engineretries int
+	Pvlength int
+	Bookletencoding string
+	Analysismode string
+	Enginenodes int
+	Enginemovetime int
+	Enginetimeout int
+	Bookstore BookStore
+	Engine *Engine
+	Enginepool *EnginePool
 	Poscache map[string]BookPosition
+	Poslock *sync.RWMutex
 }
 
-func (b Book) Updatefield(key string, value string){
-	Updatebookfield(b, key, value)
+func (b Book) Updatefield(key string, value string) error{
+	return Updatebookfield(b, key, value)
 }
 
-func (b *Book) Synccache(){
-	Synccache(b)
+func (b *Book) Synccache() error{
+	return Synccache(b)
 }
 
-func (b Book) Uploadcache(){
-	Uploadcache(b)
+func (b Book) Uploadcache() error{
+	return Uploadcache(b)
 }
 
 func (b Book) Id() string{
@@ -148,8 +153,14 @@ func (b Book) Fullname() string{
 	return fmt.Sprintf("[Book %s %s]", b.Name, b.Variantkey)
 }
 
-func NewBook() Book{
-	return Book{
+func NewBook(store BookStore) (Book, error){
+	return newbook(store, nil)
+}
+
+// newbook returns the book set by the environment and the stored book meta,
+// the given settings override both
+func newbook(store BookStore, fields map[string]string) (Book, error){
+	b := Book{
 		Name: Envstr("BOOKNAME", "default"),
 		Variantkey: Envstr("BOOKVARIANT", "atomic"),
 		Rootfen: Envstr("ANALYSISROOT", START_FEN),
@@ -161,18 +172,225 @@ func NewBook() Book{
 		Minimaxafter: Envint("MINIMAXAFTER", 3),
 		Cutoff: Envint("CUTOFF", 1000),
 		Widths: Envintarray("WIDTHS", []int{3,2,1}),
+		Poskey: Envstr("BOOKPOSKEY", POSKEY_POSID),
+		Enginepath: Envstr("ENGINEPATH", DEFAULT_ENGINE_PATH),
+		Enginehash: Envint("ENGINEHASH", DEFAULT_ENGINE_HASH),
+		Enginethreads: Envint("ENGINETHREADS", DEFAULT_ENGINE_THREADS),
+		Enginemultipv: Envint("ENGINEMULTIPV", DEFAULT_ENGINE_MULTIPV),
+		Engineretries: Envint("ENGINERETRIES", DEFAULT_ENGINE_RETRIES),
+		Pvlength: Envint("PVLENGTH", DEFAULT_PV_LENGTH),
+		Bookletencoding: Envstr("BOOKLETENCODING", BOOKLET_TEXT),
+		Analysismode: Envstr("ANALYSISMODE", ANALYSIS_DEPTH),
+		Enginenodes: Envint("ENGINENODES", 0),
+		Enginemovetime: Envint("ENGINEMOVETIME", 0),
+		Enginetimeout: Envint("ENGINETIMEOUT", 0),
+		Bookstore: store,
 		Poscache: make(map[string]BookPosition),
+		Poslock: &sync.RWMutex{},
+	}
+	err := Checkvariant(b.Variantkey)
+	if err != nil{
+		return b, err
+	}
+	_, err = ParseFEN(b.Rootfen)
+	if err != nil{
+		return b, fmt.Errorf("invalid root : %v", err)
+	}
+	err = b.Loadmeta()
+	if err != nil{
+		return b, fmt.Errorf("book meta could not be loaded : %v", err)
+	}
+	err = b.Setfields(fields)
+	if err != nil{
+		return b, err
+	}
+	if ( b.Poskey != POSKEY_POSID ) && ( b.Poskey != POSKEY_ZOBRIST ){
+		return b, fmt.Errorf("invalid position key %q", b.Poskey)
+	}
+	err = Checkbookletencoding(b.Bookletencoding)
+	if err != nil{
+		return b, err
+	}
+	if b.Pvlength < 0{
+		return b, fmt.Errorf("invalid pv length %d", b.Pvlength)
+	}
+	return b, b.Checksearch()
+}
+
+// Checksearch tells whether the analysis mode has a positive limit, a search
+// without limits only ends when the engine timeout stops it
+func (b Book) Checksearch() error{
+	err := Checkanalysismode(b.Analysismode)
+	if err != nil{
+		return err
+	}
+	if ( b.Analysismode == ANALYSIS_NODES ) && ( b.Enginenodes <= 0 ){
+		return fmt.Errorf("nodes analysis mode needs positive engine nodes, not %d", b.Enginenodes)
+	}
+	if ( b.Analysismode == ANALYSIS_MOVETIME ) && ( b.Enginemovetime <= 0 ){
+		return fmt.Errorf("movetime analysis mode needs a positive engine movetime, not %d", b.Enginemovetime)
+	}
+	if b.Enginedepth < 0{
+		return fmt.Errorf("invalid engine depth %d", b.Enginedepth)
+	}
+	if b.Enginetimeout < 0{
+		return fmt.Errorf("invalid engine timeout %d", b.Enginetimeout)
+	}
+	if ( b.Searchlimits() == SearchLimits{} ) && ( b.Enginetimeout == 0 ){
+		return fmt.Errorf("infinite analysis needs an engine timeout")
+	}
+	return nil
+}
+
+// Loadmeta applies the stored settings that determine how the positions of the
+// book are keyed, sharded and encoded, they override the environment, so a
+// stored book is only rekeyed by migrating it and reencoded by Reencode, and
+// the stored engine settings the environment does not set
+func (b *Book) Loadmeta() error{
+	if b.Bookstore == nil{
+		return nil
+	}
+	meta, err := b.Bookstore.Loadbook(b.Id())
+	if ( err != nil ) || ( meta == nil ){
+		return err
+	}
+	if mod, ok := meta["mod"].(string); ok{
+		b.Mod = str2int(mod, b.Mod)
+	}
+	if poskey, ok := meta["poskey"].(string); ok{
+		b.Poskey = poskey
+	}
+	if encoding, ok := meta["bookletencoding"].(string); ok{
+		b.Bookletencoding = encoding
+	}
+	// the book is built with the engine settings it was built with,
+	// unless the environment sets them explicitly
+	if enginepath, ok := meta["enginepath"].(string); ok && !Hasenv("ENGINEPATH"){
+		b.Enginepath = enginepath
+	}
+	if hash, ok := meta["enginehash"].(string); ok && !Hasenv("ENGINEHASH"){
+		b.Enginehash = str2int(hash, b.Enginehash)
+	}
+	if threads, ok := meta["enginethreads"].(string); ok && !Hasenv("ENGINETHREADS"){
+		b.Enginethreads = str2int(threads, b.Enginethreads)
+	}
+	if multipv, ok := meta["enginemultipv"].(string); ok && !Hasenv("ENGINEMULTIPV"){
+		b.Enginemultipv = str2int(multipv, b.Enginemultipv)
+	}
+	return nil
+}
+
+// Setfields sets the engine and analysis settings given by their book meta
+// keys, they override the stored settings and the environment
+func (b *Book) Setfields(fields map[string]string) error{
+	for key, value := range(fields){
+		var ptr *int
+		switch key{
+		case "enginepath":
+			b.Enginepath = value
+			continue
+		case "analysismode":
+			b.Analysismode = value
+			continue
+		case "enginehash":
+			ptr = &b.Enginehash
+		case "enginethreads":
+			ptr = &b.Enginethreads
+		case "enginemultipv":
+			ptr = &b.Enginemultipv
+		case "enginenodes":
+			ptr = &b.Enginenodes
+		case "enginemovetime":
+			ptr = &b.Enginemovetime
+		case "enginetimeout":
+			ptr = &b.Enginetimeout
+		default:
+			return fmt.Errorf("unknown book setting %q", key)
+		}
+		intvalue, err := strconv.Atoi(value)
+		if err != nil{
+			return fmt.Errorf("invalid book setting %s %q", key, value)
+		}
+		*ptr = intvalue
+	}
+	return nil
+}
+
+// Checkanalysismode tells whether the analysis mode is known
+func Checkanalysismode(mode string) error{
+	if ( mode != ANALYSIS_DEPTH ) && ( mode != ANALYSIS_NODES ) && ( mode != ANALYSIS_MOVETIME ){
+		return fmt.Errorf("invalid analysis mode %q", mode)
+	}
+	return nil
+}
+
+// Poskeyof returns the key of a position in the position cache and the booklets,
+// the posid made of the fen without clocks or the hex zobrist key of the position
+func (b Book) Poskeyof(fen string) (string, error){
+	if b.Poskey == POSKEY_ZOBRIST{
+		return Fen2zobristkeyhex(fen)
+	}
+	return Fen2posid(fen), nil
+}
+
+// Boardkey returns the key of the board position, using the incremental zobrist key
+func (b Book) Boardkey(board Board) string{
+	if b.Poskey == POSKEY_ZOBRIST{
+		return Zobristkeyhex(board.Zobrist)
+	}
+	return Fen2posid(board.Tofen())
+}
+
+// Migrateposkeys rekeys the position cache with another position key,
+// uploading the cache afterwards replaces the booklets with rekeyed ones
+func (b *Book) Migrateposkeys(poskey string) error{
+	if ( poskey != POSKEY_POSID ) && ( poskey != POSKEY_ZOBRIST ){
+		return fmt.Errorf("invalid position key %q", poskey)
+	}
+	defer b.lockcache()()
+	rekeyed := Book{Poskey: poskey}
+	poscache := make(map[string]BookPosition)
+	for _, p := range(b.Poscache){
+		key, err := rekeyed.Poskeyof(p.Fen)
+		if err != nil{
+			return err
+		}
+		poscache[key] = p
+	}
+	b.Poskey = poskey
+	b.Poscache = poscache
+	return nil
+}
+
+// Reencode sets the booklet encoding of the book, uploading the cache
+// afterwards replaces the booklets with reencoded ones
+func (b *Book) Reencode(encoding string) error{
+	err := Checkbookletencoding(encoding)
+	if err != nil{
+		return err
 	}
+	b.Bookletencoding = encoding
+	return nil
 }
 
 func (b Book) Getpos(fen string) (BookPosition, bool){
-	posid := Fen2posid(fen)
-	p, ok := b.Poscache[posid]
+	defer b.rlockcache()()
+	return b.getpos(fen)
+}
+
+// getpos looks up the position in the cache, the caller holds the cache lock,
+// positions with an invalid fen are never in the cache
+func (b Book) getpos(fen string) (BookPosition, bool){
+	key, err := b.Poskeyof(fen)
+	if err != nil{
+		return BookPosition{}, false
+	}
+	p, ok := b.Poscache[key]
 	return p, ok
 }
 
 func (b Book) Serialize() map[string]interface{}{
-	return map[string]interface{}{
+	meta := map[string]interface{}{
 		"name": b.Name,
 		"variantkey": b.Variantkey,
 		"rootfen": b.Rootfen,
@@ -184,24 +402,149 @@ func (b Book) Serialize() map[string]interface{}{
 		"minimaxafter": strconv.Itoa(b.Minimaxafter),
 		"cutoff": strconv.Itoa(b.Cutoff),
 		"widths": Intarray2str(b.Widths),
-		"booklets": b.Booklets,
+		"poskey": b.Poskey,
+		"enginepath": b.Enginepath,
+		"enginehash": strconv.Itoa(b.Enginehash),
+		"enginethreads": strconv.Itoa(b.Enginethreads),
+		"enginemultipv": strconv.Itoa(b.Enginemultipv),
+		"pvlength": strconv.Itoa(b.Pvlength),
+		"bookletencoding": b.Bookletencoding,
+		"analysismode": b.Analysismode,
+		"enginenodes": strconv.Itoa(b.Enginenodes),
+		"enginemovetime": strconv.Itoa(b.Enginemovetime),
+		"enginetimeout": strconv.Itoa(b.Enginetimeout),
+	}
+	if b.Engine != nil{
+		meta["enginename"] = b.Engine.Name
 	}
+	return meta
 }
 
-func (b Book) Store(){
-	StoreBook(b)
+func (b Book) Store() error{
+	return StoreBook(b)
 }
 
 func (b Book) Bookletid(fen string) string{
 	return Bookletid(fen, b.Mod)
 }
 
-func (b Book) Analyze(fen string) BookPosition{
-	return Analyze(fen, b.Enginedepth, b.Variantkey)
+// Engineoptions returns the engine options the book is analyzed with
+func (b Book) Engineoptions() Options{
+	return Options{
+		UCI_Variant: b.Variantkey,
+		Hash: b.Enginehash,
+		Threads: b.Enginethreads,
+		MultiPV: b.Enginemultipv,
+	}
+}
+
+// Analyze analyzes the position with the book engine
+func (b Book) Analyze(fen string) (BookPosition, error){
+	return b.Analyzewith(b.Engine, fen)
 }
 
-func (b Book) StorePosition(p BookPosition){
-	b.Poscache[p.Posid()] = p	
+// Analyzewith analyzes the position with the given engine, terminal positions
+// are stored without moves and without calling the engine, moves of the
+// engine that are not legal are dropped
+func (b Book) Analyzewith(eng *Engine, fen string) (BookPosition, error){
+	if !Hasmovegen(b.Variantkey){
+		return b.Analyzeretry(eng, fen)
+	}
+	board := NewBoard(b.Variantkey)
+	err := board.Setfromfen(fen)
+	if err != nil{
+		return NewPosition(fen), err
+	}
+	if over, result := board.Gameover(); over{
+		fmt.Println("game over", result, fen)
+		p := NewPosition(fen)
+		p.Enginedepth = b.Enginedepth
+		return p, nil
+	}
+	p, err := b.Analyzeretry(eng, fen)
+	if err != nil{
+		return p, err
+	}
+	legalmoves := make([]BookMove, 0)
+	for _, m := range(p.Moves){
+		if board.Islegal(m.Algeb){
+			legalmoves = append(legalmoves, m)
+		}else{
+			fmt.Println("dropping illegal engine move", m.Algeb, fen)
+		}
+	}
+	p.Moves = legalmoves
+	return p, nil
+}
+
+// Searchlimits returns the search limits of the analysis mode of the book
+func (b Book) Searchlimits() SearchLimits{
+	switch b.Analysismode{
+	case ANALYSIS_NODES:
+		return SearchLimits{Nodes: b.Enginenodes}
+	case ANALYSIS_MOVETIME:
+		return SearchLimits{Movetime: b.Enginemovetime}
+	}
+	return SearchLimits{Depth: b.Enginedepth}
+}
+
+// Analyzeretry runs the engine analysis within Enginetimeout milliseconds,
+// restarting the engine and retrying up to Engineretries times if the
+// engine process or its pipes fail, other errors are returned at once
+func (b Book) Analyzeretry(eng *Engine, fen string) (BookPosition, error){
+	if eng == nil{
+		return NewPosition(fen), fmt.Errorf("no engine to analyze %s", fen)
+	}
+	analyze := func() (BookPosition, error){
+		ctx := context.Background()
+		if b.Enginetimeout > 0{
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(b.Enginetimeout) * time.Millisecond)
+			defer cancel()
+		}
+		return eng.Analyze(ctx, fen, b.Searchlimits(), b.Engineoptions())
+	}
+	p, err := analyze()
+	for retry := 1; eng.Failed(err) && ( retry <= b.Engineretries ); retry++{
+		fmt.Println("engine failed", err, "restarting, retry", retry, "of", b.Engineretries)
+		err = eng.Restart()
+		if err == nil{
+			p, err = analyze()
+		}
+	}
+	if err != nil{
+		return p, fmt.Errorf("analysis of %s failed: %w", fen, err)
+	}
+	for i, m := range(p.Moves){
+		if len(m.Pv) > b.Pvlength{
+			p.Moves[i].Pv = m.Pv[:b.Pvlength]
+		}
+	}
+	return p, nil
+}
+
+func (b Book) StorePosition(p BookPosition) error{
+	key, err := b.Poskeyof(p.Fen)
+	if err != nil{
+		return err
+	}
+	defer b.lockcache()()
+	b.Poscache[key] = p
+	return nil
+}
+
+// storenew stores the position if it is not in the cache yet and tells whether it did
+func (b Book) storenew(p BookPosition) (bool, error){
+	key, err := b.Poskeyof(p.Fen)
+	if err != nil{
+		return false, err
+	}
+	defer b.lockcache()()
+	if _, ok := b.Poscache[key]; ok{
+		return false, nil
+	}
+	b.Poscache[key] = p
+	return true, nil
 }
 
 ////////////////////////////////////////////////////////////////
\ No newline at end of file
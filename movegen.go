@@ -0,0 +1,384 @@
+////////////////////////////////////////////////////////////////
+
+package abb
+
+////////////////////////////////////////////////////////////////
+
+import(
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////
+
+const WHITE = 1
+const BLACK = 0
+
+var KNIGHT_DELTAS = [][2]int{{1,2},{2,1},{2,-1},{1,-2},{-1,-2},{-2,-1},{-2,1},{-1,2}}
+var KING_DELTAS = [][2]int{{1,0},{1,1},{0,1},{-1,1},{-1,0},{-1,-1},{0,-1},{1,-1}}
+var ROOK_DIRS = [][2]int{{1,0},{0,1},{-1,0},{0,-1}}
+var BISHOP_DIRS = [][2]int{{1,1},{-1,1},{-1,-1},{1,-1}}
+
+var PROMOTION_KINDS = []string{"q", "r", "b", "n"}
+
+////////////////////////////////////////////////////////////////
+
+// Hasmovegen tells whether legal moves of the variant can be generated
+func Hasmovegen(variantkey string) bool{
+	return Isatomic(variantkey) || ( variantkey == "chess" ) || ( variantkey == "standard" )
+}
+
+// Turn returns the color of the side to move
+func (b Board) Turn() int{
+	if b.Turnfen == "w"{
+		return WHITE
+	}
+	return BLACK
+}
+
+// Kingindex returns the index of the king of the given color or -1 if there is none
+func (b Board) Kingindex(color int) int{
+	for i, p := range(b.Rep){
+		if ( p.Kind == "k" ) && ( p.Color == color ){
+			return i
+		}
+	}
+	return -1
+}
+
+func kingsadjacent(k1 int, k2 int) bool{
+	if ( k1 < 0 ) || ( k2 < 0 ){
+		return false
+	}
+	di := k1%8 - k2%8
+	dj := k1/8 - k2/8
+	return ( di >= -1 ) && ( di <= 1 ) && ( dj >= -1 ) && ( dj <= 1 )
+}
+
+func (b Board) pieceat(i int, j int, kind string, color int) bool{
+	p := b.Rep[index(i, j)]
+	return ( p.Kind == kind ) && ( p.Color == color )
+}
+
+// Attacked tells whether the square is attacked by pieces of the given color,
+// the king is only counted as an attacker if withking is set
+func (b Board) Attacked(sq int, bycolor int, withking bool) bool{
+	i := sq % 8
+	j := sq / 8
+	// pawns of the attacking color capture towards the square from behind it
+	pj := j + 1
+	if bycolor == BLACK{
+		pj = j - 1
+	}
+	for _, pi := range([]int{i-1, i+1}){
+		if ijok(pi, pj) && b.pieceat(pi, pj, "p", bycolor){
+			return true
+		}
+	}
+	for _, d := range(KNIGHT_DELTAS){
+		if ijok(i+d[0], j+d[1]) && b.pieceat(i+d[0], j+d[1], "n", bycolor){
+			return true
+		}
+	}
+	if withking{
+		for _, d := range(KING_DELTAS){
+			if ijok(i+d[0], j+d[1]) && b.pieceat(i+d[0], j+d[1], "k", bycolor){
+				return true
+			}
+		}
+	}
+	sliders := func(dirs [][2]int, kinds string) bool{
+		for _, d := range(dirs){
+			ni := i + d[0]
+			nj := j + d[1]
+			for ijok(ni, nj){
+				p := b.Rep[index(ni, nj)]
+				if p.Kind != "-"{
+					if ( p.Color == bycolor ) && strings.Contains(kinds, p.Kind){
+						return true
+					}
+					break
+				}
+				ni += d[0]
+				nj += d[1]
+			}
+		}
+		return false
+	}
+	return sliders(ROOK_DIRS, "rq") || sliders(BISHOP_DIRS, "bq")
+}
+
+// Checked tells whether the king of the given color is in check, in atomic
+// a king touching the opponent king is never in check and kings do not give check
+func (b Board) Checked(color int) bool{
+	kingindex := b.Kingindex(color)
+	if kingindex < 0{
+		return false
+	}
+	if Isatomic(b.Variantkey){
+		if kingsadjacent(kingindex, b.Kingindex(1-color)){
+			return false
+		}
+		return b.Attacked(kingindex, 1-color, false)
+	}
+	return b.Attacked(kingindex, 1-color, true)
+}
+
+// Incheck tells whether the side to move is in check
+func (b Board) Incheck() bool{
+	return b.Checked(b.Turn())
+}
+
+// Pseudolegalmoves returns the uci moves of the side to move that obey
+// the piece movement rules, ignoring whether the own king is left in check
+func (b Board) Pseudolegalmoves() []string{
+	moves := []string{}
+	color := b.Turn()
+	atomic := Isatomic(b.Variantkey)
+	add := func(fromi, fromj, toi, toj int){
+		moves = append(moves, ijalgeb(fromi, fromj) + ijalgeb(toi, toj))
+	}
+	for sq, p := range(b.Rep){
+		if ( p.Kind == "-" ) || ( p.Color != color ){
+			continue
+		}
+		i := sq % 8
+		j := sq / 8
+		switch p.Kind{
+		case "p":
+			dj := -1
+			startj := 6
+			promj := 0
+			if color == BLACK{
+				dj = 1
+				startj = 1
+				promj = 7
+			}
+			addpawn := func(toi, toj int){
+				if toj == promj{
+					for _, kind := range(PROMOTION_KINDS){
+						moves = append(moves, ijalgeb(i, j) + ijalgeb(toi, toj) + kind)
+					}
+				}else{
+					add(i, j, toi, toj)
+				}
+			}
+			if ijok(i, j+dj) && ( b.Rep[index(i, j+dj)].Kind == "-" ){
+				addpawn(i, j+dj)
+				if ( j == startj ) && ( b.Rep[index(i, j+2*dj)].Kind == "-" ){
+					add(i, j, i, j+2*dj)
+				}
+			}
+			for _, ci := range([]int{i-1, i+1}){
+				if !ijok(ci, j+dj){
+					continue
+				}
+				tp := b.Rep[index(ci, j+dj)]
+				if ( tp.Kind != "-" ) && ( tp.Color != color ){
+					addpawn(ci, j+dj)
+				}else if ( tp.Kind == "-" ) && ( ijalgeb(ci, j+dj) == b.Epfen ){
+					add(i, j, ci, j+dj)
+				}
+			}
+		case "n", "k":
+			deltas := KNIGHT_DELTAS
+			if p.Kind == "k"{
+				deltas = KING_DELTAS
+			}
+			for _, d := range(deltas){
+				ni := i + d[0]
+				nj := j + d[1]
+				if !ijok(ni, nj){
+					continue
+				}
+				tp := b.Rep[index(ni, nj)]
+				if tp.Kind == "-"{
+					add(i, j, ni, nj)
+				}else if ( tp.Color != color ) && !( atomic && ( p.Kind == "k" ) ){
+					// kings cannot capture in atomic
+					add(i, j, ni, nj)
+				}
+			}
+		default:
+			dirs := append(append([][2]int{}, ROOK_DIRS...), BISHOP_DIRS...)
+			if p.Kind == "r"{
+				dirs = ROOK_DIRS
+			}
+			if p.Kind == "b"{
+				dirs = BISHOP_DIRS
+			}
+			for _, d := range(dirs){
+				ni := i + d[0]
+				nj := j + d[1]
+				for ijok(ni, nj){
+					tp := b.Rep[index(ni, nj)]
+					if tp.Kind == "-"{
+						add(i, j, ni, nj)
+					}else{
+						if tp.Color != color{
+							add(i, j, ni, nj)
+						}
+						break
+					}
+					ni += d[0]
+					nj += d[1]
+				}
+			}
+		}
+	}
+	return append(moves, b.castlingmoves()...)
+}
+
+// castlingmoves returns the castling moves allowed by the castling rights,
+// with empty squares between king and rook and the king not passing
+// through or out of check
+func (b Board) castlingmoves() []string{
+	moves := []string{}
+	color := b.Turn()
+	rights := []struct{
+		right string
+		algeb string
+		kingsq int
+		rooksq int
+		empty []int
+		passing []int
+	}{
+		{"K", "e1g1", 60, 63, []int{61, 62}, []int{61, 62}},
+		{"Q", "e1c1", 60, 56, []int{57, 58, 59}, []int{59, 58}},
+		{"k", "e8g8", 4, 7, []int{5, 6}, []int{5, 6}},
+		{"q", "e8c8", 4, 0, []int{1, 2, 3}, []int{3, 2}},
+	}
+	for _, r := range(rights){
+		if !strings.Contains(b.Castlefen, r.right){
+			continue
+		}
+		if ( ( color == WHITE ) != ( r.kingsq == 60 ) ) || !b.pieceat(r.kingsq%8, r.kingsq/8, "k", color) || !b.pieceat(r.rooksq%8, r.rooksq/8, "r", color){
+			continue
+		}
+		ok := true
+		for _, sq := range(r.empty){
+			if b.Rep[sq].Kind != "-"{
+				ok = false
+			}
+		}
+		if !ok || b.Checked(color){
+			continue
+		}
+		for _, sq := range(r.passing){
+			testboard := b.copy()
+			testboard.Rep[sq] = testboard.Rep[r.kingsq]
+			testboard.Rep[r.kingsq] = EMPTY_PIECE
+			if testboard.Checked(color){
+				ok = false
+			}
+		}
+		if ok{
+			moves = append(moves, r.algeb)
+		}
+	}
+	return moves
+}
+
+// legalafter tells whether the side that made a move may leave the
+// board in this state, in atomic exploding the own king is illegal,
+// exploding the opponent king is always legal
+func (b Board) legalafter(color int) bool{
+	if b.Kingindex(color) < 0{
+		return false
+	}
+	if Isatomic(b.Variantkey) && ( b.Kingindex(1-color) < 0 ){
+		return true
+	}
+	return !b.Checked(color)
+}
+
+// LegalMoves returns the legal uci moves of the side to move, the rules
+// of atomic are applied for the atomic variant, standard chess rules otherwise
+func (b Board) LegalMoves() []string{
+	moves := []string{}
+	if b.Variantend(){
+		return moves
+	}
+	color := b.Turn()
+	for _, algeb := range(b.Pseudolegalmoves()){
+		newboard := b.copy()
+		newboard.Makealgebmove(algeb)
+		if newboard.legalafter(color){
+			moves = append(moves, algeb)
+		}
+	}
+	return moves
+}
+
+// Islegal tells whether the uci move is legal
+func (b Board) Islegal(algeb string) bool{
+	for _, move := range(b.LegalMoves()){
+		if move == algeb{
+			return true
+		}
+	}
+	return false
+}
+
+// Mobility returns the number of legal moves of the side to move
+func (b Board) Mobility() int{
+	return len(b.LegalMoves())
+}
+
+// Variantend tells whether the game ended by a variant rule,
+// in atomic when a king has exploded
+func (b Board) Variantend() bool{
+	if Isatomic(b.Variantkey){
+		return ( b.Kingindex(WHITE) < 0 ) || ( b.Kingindex(BLACK) < 0 )
+	}
+	return false
+}
+
+// Gameover tells whether the game ended and returns the result,
+// 1-0, 0-1 or 1/2-1/2, or * if the game is not over
+func (b Board) Gameover() (bool, string){
+	wins := map[int]string{WHITE: "1-0", BLACK: "0-1"}
+	if b.Variantend(){
+		if b.Kingindex(WHITE) < 0{
+			return true, wins[BLACK]
+		}
+		return true, wins[WHITE]
+	}
+	if len(b.LegalMoves()) > 0{
+		return false, "*"
+	}
+	if b.Incheck(){
+		return true, wins[1-b.Turn()]
+	}
+	return true, "1/2-1/2"
+}
+
+// Perft counts the leaf nodes of the legal move tree of the given depth
+func (b Board) Perft(depth int) int{
+	if depth <= 0{
+		return 1
+	}
+	moves := b.LegalMoves()
+	if depth == 1{
+		return len(moves)
+	}
+	nodes := 0
+	for _, algeb := range(moves){
+		newboard := b.copy()
+		newboard.Makealgebmove(algeb)
+		nodes += newboard.Perft(depth - 1)
+	}
+	return nodes
+}
+
+// Perftdivide returns the perft of the given depth below each legal move
+func (b Board) Perftdivide(depth int) map[string]int{
+	divide := make(map[string]int)
+	for _, algeb := range(b.LegalMoves()){
+		newboard := b.copy()
+		newboard.Makealgebmove(algeb)
+		divide[algeb] = newboard.Perft(depth - 1)
+	}
+	return divide
+}
+
+////////////////////////////////////////////////////////////////
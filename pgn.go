@@ -0,0 +1,212 @@
+////////////////////////////////////////////////////////////////
+
+package abb
+
+////////////////////////////////////////////////////////////////
+
+import(
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////
+
+// pgn Variant header values of the supported variant keys
+var PGN_VARIANTS = map[string]string{
+	"standard": "Standard",
+	"chess": "Standard",
+	"atomic": "Atomic",
+}
+
+////////////////////////////////////////////////////////////////
+
+// Pgnvariant returns the pgn Variant header value of a variant key,
+// unknown variants keep their key
+func Pgnvariant(variantkey string) string{
+	if variant, ok := PGN_VARIANTS[variantkey]; ok{
+		return variant
+	}
+	return variantkey
+}
+
+// Pgneval formats a score of the side to move as a lichess eval
+// annotation from the point of view of white, mates as #n
+func Pgneval(score int, turn int) string{
+	if turn == BLACK{
+		score = -score
+	}
+	if score > MATE_SCORE{
+		return fmt.Sprintf("#%d", INF_SCORE - score)
+	}
+	if score < -MATE_SCORE{
+		return fmt.Sprintf("#-%d", INF_SCORE + score)
+	}
+	return fmt.Sprintf("%.2f", float64(score) / 100)
+}
+
+// pgnmoves returns the moves of the position exported to pgn, the best
+// move and the moves leading to book positions, moves outside the cutoff
+// are never exported, not even the best move, nor are corrupt moves
+func (b Book) pgnmoves(board Board, p BookPosition) []BookMove{
+	moves := []BookMove{}
+	for i, m := range(p.Getmovelist().Items){
+		if board.Checkalgeb(m.Algeb) != nil{
+			continue
+		}
+		incutoff := ( m.Score >= -b.Cutoff ) && ( m.Score <= b.Cutoff )
+		if !incutoff{
+			continue
+		}
+		if i == 0{
+			moves = append(moves, m)
+			continue
+		}
+		newboard := board.copy()
+		newboard.Makealgebmove(m.Algeb)
+		if _, ok := b.Poscache[b.Boardkey(newboard)]; ok{
+			moves = append(moves, m)
+		}
+	}
+	return moves
+}
+
+// Pgnwdl formats the win, draw and loss permille of the side to move
+// from the point of view of white
+func Pgnwdl(wdl [3]int, turn int) string{
+	if turn == BLACK{
+		wdl[0], wdl[2] = wdl[2], wdl[0]
+	}
+	return fmt.Sprintf("%d/%d/%d", wdl[0], wdl[1], wdl[2])
+}
+
+// pgnmove returns the san of the move with its move number and a comment
+// with the eval, the score, the engine wdl if reported and the engine pv
+func pgnmove(board Board, m BookMove, withblacknumber bool) string{
+	buff := ""
+	if board.Turn() == WHITE{
+		buff = fmt.Sprintf("%d. ", board.Fullmove)
+	}else if withblacknumber{
+		buff = fmt.Sprintf("%d... ", board.Fullmove)
+	}
+	comment := fmt.Sprintf("[%%eval %s] score %s", Pgneval(m.Eval, board.Turn()), Pgneval(m.Score, board.Turn()))
+	if m.Haswdl(){
+		comment += " wdl " + Pgnwdl(m.Wdl, board.Turn())
+	}
+	if pv := pgnpv(board, m.Line()); pv != ""{
+		comment += " pv " + pv
+	}
+	return buff + fmt.Sprintf("%s {%s}", board.ToSAN(m.Algeb), comment)
+}
+
+// pgnpv returns the continuation of the line after its first move in san,
+// up to the first invalid move
+func pgnpv(board Board, line []string) string{
+	board = board.copy()
+	sans := []string{}
+	for i, algeb := range(line){
+		if board.Checkalgeb(algeb) != nil{
+			break
+		}
+		if Hasmovegen(board.Variantkey) && !board.Islegal(algeb){
+			break
+		}
+		if i > 0{
+			sans = append(sans, board.ToSAN(algeb))
+		}
+		board.Makealgebmove(algeb)
+	}
+	return strings.Join(sans, " ")
+}
+
+// pgnrecursive returns the movetext of the book tree below the board,
+// the first move is the main line, the others are variations, the tree
+// ends at positions imported without analysis
+func (b Book) pgnrecursive(board Board, posids []string, depth int) string{
+	if depth > b.Analysisdepth{
+		return ""
+	}
+	posid := b.Boardkey(board)
+	for _, testposid := range(posids){
+		if testposid == posid{
+			return ""
+		}
+	}
+	p, ok := b.Poscache[posid]
+	// imported positions have no engine evals to comment
+	if !ok || p.Isimported(){
+		return ""
+	}
+	moves := b.pgnmoves(board, p)
+	if len(moves) == 0{
+		return ""
+	}
+	posids = append(posids, posid)
+	buffs := []string{pgnmove(board, moves[0], true)}
+	for _, m := range(moves[1:]){
+		newboard := board.copy()
+		newboard.Makealgebmove(m.Algeb)
+		variation := pgnmove(board, m, true)
+		if rest := b.pgnrecursive(newboard, posids, depth + 1); rest != ""{
+			variation += " " + rest
+		}
+		buffs = append(buffs, "(" + variation + ")")
+	}
+	newboard := board.copy()
+	newboard.Makealgebmove(moves[0].Algeb)
+	if rest := b.pgnrecursive(newboard, posids, depth + 1); rest != ""{
+		buffs = append(buffs, rest)
+	}
+	return strings.Join(buffs, " ")
+}
+
+// Pgn returns the book tree from the root down to the analysis depth
+// as a single pgn with variations and eval comments
+func (b Book) Pgn() (string, error){
+	board := NewBoard(b.Variantkey)
+	err := board.Setfromfen(b.Rootfen)
+	if err != nil{
+		return "", err
+	}
+	headers := [][2]string{
+		{"Event", b.Fullname()},
+		{"Site", "?"},
+		{"Date", time.Now().UTC().Format("2006.01.02")},
+		{"White", "?"},
+		{"Black", "?"},
+		{"Result", "*"},
+		{"Variant", Pgnvariant(b.Variantkey)},
+	}
+	if board.Tofen() != START_FEN{
+		headers = append(headers, [2]string{"FEN", board.Tofen()}, [2]string{"SetUp", "1"})
+	}
+	buff := ""
+	for _, header := range(headers){
+		buff += fmt.Sprintf("[%s \"%s\"]\n", header[0], header[1])
+	}
+	unlock := b.rlockcache()
+	movetext := b.pgnrecursive(board, []string{}, 0)
+	unlock()
+	if movetext != ""{
+		movetext += " "
+	}
+	return buff + "\n" + movetext + "*\n", nil
+}
+
+// Exportpgnfile writes the book tree to a pgn file
+func (b Book) Exportpgnfile(path string) error{
+	fmt.Println("exporting", b.Fullname(), "to", path)
+	pgn, err := b.Pgn()
+	if err != nil{
+		return err
+	}
+	err = os.WriteFile(path, []byte(pgn), 0644)
+	if err != nil{
+		return err
+	}
+	fmt.Println("exporting done", b.Fullname())
+	return nil
+}
+
+////////////////////////////////////////////////////////////////
@@ -0,0 +1,281 @@
+////////////////////////////////////////////////////////////////
+
+package abb
+
+////////////////////////////////////////////////////////////////
+
+import(
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+////////////////////////////////////////////////////////////////
+
+const POLYGLOT_MAX_WEIGHT = 65535
+// eval difference to the best move in centipawns that reduces the weight by a factor of e
+const POLYGLOT_WEIGHT_SCALE = 50.0
+
+var POLYGLOT_PROMOTIONS = map[string]uint16{"n": 1, "b": 2, "r": 3, "q": 4}
+
+// Polyglot encodes castling as the king capturing its own rook
+var POLYGLOT_CASTLINGS = map[string]string{"e1g1": "e1h1", "e1c1": "e1a1", "e8g8": "e8h8", "e8c8": "e8a8"}
+
+////////////////////////////////////////////////////////////////
+
+// PolyglotEntry is one 16 byte entry of a Polyglot book
+type PolyglotEntry struct{
+	Key uint64
+	Move uint16
+	Weight uint16
+	Learn uint32
+}
+
+// Polyglotmove encodes a uci move of the board in Polyglot move format
+func Polyglotmove(board Board, algeb string) uint16{
+	fromi, fromj := Sqindeces(algeb[0:2])
+	if board.Rep[index(fromi, fromj)].Kind == "k"{
+		castling, ok := POLYGLOT_CASTLINGS[algeb]
+		if ok{
+			algeb = castling
+		}
+	}
+	toi, toj := Sqindeces(algeb[2:4])
+	move := uint16(toi) | uint16(7 - toj) << 3 | uint16(fromi) << 6 | uint16(7 - fromj) << 9
+	if len(algeb) == 5{
+		move |= POLYGLOT_PROMOTIONS[algeb[4:5]] << 12
+	}
+	return move
+}
+
+// Polyglotalgeb decodes a Polyglot move of the board to a uci move
+func Polyglotalgeb(board Board, move uint16) string{
+	algeb := ijalgeb(int(move >> 6 & 7), 7 - int(move >> 9 & 7)) + ijalgeb(int(move & 7), 7 - int(move >> 3 & 7))
+	promotion := move >> 12 & 7
+	for kind, code := range(POLYGLOT_PROMOTIONS){
+		if code == promotion{
+			algeb += kind
+		}
+	}
+	fromi, fromj := Sqindeces(algeb[0:2])
+	if board.Rep[index(fromi, fromj)].Kind == "k"{
+		for castling, polyglotcastling := range(POLYGLOT_CASTLINGS){
+			if algeb == polyglotcastling{
+				algeb = castling
+			}
+		}
+	}
+	return algeb
+}
+
+// Polyglotweight converts the eval of a move to a Polyglot weight,
+// the best move gets the maximum weight, worse moves get exponentially
+// smaller weights down to zero
+func Polyglotweight(eval int, besteval int) uint16{
+	weight := POLYGLOT_MAX_WEIGHT * math.Exp(-float64(besteval - eval) / POLYGLOT_WEIGHT_SCALE)
+	return uint16(math.Round(weight))
+}
+
+// Polyglotscore converts a Polyglot weight to a score relative to the
+// maximum weight of the position, the inverse of Polyglotweight
+func Polyglotscore(weight uint16, maxweight uint16) int{
+	if ( weight == 0 ) || ( maxweight == 0 ){
+		return -INF_SCORE
+	}
+	return int(math.Round(-POLYGLOT_WEIGHT_SCALE * math.Log(float64(maxweight) / float64(weight))))
+}
+
+// Polyglotentries walks the book from the root through the cached positions
+// and returns the sorted Polyglot entries of all moves with positive weight
+func (b Book) Polyglotentries() ([]PolyglotEntry, error){
+	entries := []PolyglotEntry{}
+	root := NewBoard(b.Variantkey)
+	err := root.Setfromfen(b.Rootfen)
+	if err != nil{
+		return nil, err
+	}
+	visited := map[uint64]bool{}
+	boards := []Board{root}
+	for len(boards) > 0{
+		board := boards[0]
+		boards = boards[1:]
+		if visited[board.Zobrist]{
+			continue
+		}
+		visited[board.Zobrist] = true
+		p, ok := b.Getpos(board.Tofen())
+		if !ok{
+			continue
+		}
+		mli := p.Getmovelist().Items
+		if len(mli) == 0{
+			continue
+		}
+		besteval := mli[0].Eval
+		for _, m := range(mli){
+			weight := Polyglotweight(m.Eval, besteval)
+			if weight == 0{
+				continue
+			}
+			if err := board.Checkalgeb(m.Algeb); err != nil{
+				fmt.Println("skipping corrupt move", m.Algeb, board.Tofen(), err)
+				continue
+			}
+			entries = append(entries, PolyglotEntry{
+				Key: board.Zobrist,
+				Move: Polyglotmove(board, m.Algeb),
+				Weight: weight,
+			})
+			newboard := board.copy()
+			newboard.Makealgebmove(m.Algeb)
+			boards = append(boards, newboard)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool{
+		if entries[i].Key == entries[j].Key{
+			return entries[i].Weight > entries[j].Weight
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	return entries, nil
+}
+
+// Exportpolyglot writes the book in Polyglot .bin format
+func (b Book) Exportpolyglot(w io.Writer) (int, error){
+	entries, err := b.Polyglotentries()
+	if err != nil{
+		return 0, err
+	}
+	for _, entry := range(entries){
+		err := binary.Write(w, binary.BigEndian, entry)
+		if err != nil{
+			return 0, err
+		}
+	}
+	return len(entries), nil
+}
+
+// Readpolyglot reads the entries of a Polyglot .bin book
+func Readpolyglot(r io.Reader) ([]PolyglotEntry, error){
+	entries := []PolyglotEntry{}
+	for {
+		entry := PolyglotEntry{}
+		err := binary.Read(r, binary.BigEndian, &entry)
+		if err == io.EOF{
+			break
+		}
+		if err != nil{
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Importpolyglot walks the Polyglot entries from the root and stores the
+// positions not yet in the cache, with moves scored from their weights and
+// engine depth 0 so that they are selected for analysis, returns the number
+// of positions stored
+func (b Book) Importpolyglot(entries []PolyglotEntry) (int, error){
+	bykey := map[uint64][]PolyglotEntry{}
+	for _, entry := range(entries){
+		bykey[entry.Key] = append(bykey[entry.Key], entry)
+	}
+	numpos := 0
+	root := NewBoard(b.Variantkey)
+	err := root.Setfromfen(b.Rootfen)
+	if err != nil{
+		return 0, err
+	}
+	visited := map[uint64]bool{}
+	boards := []Board{root}
+	depths := []int{0}
+	for len(boards) > 0{
+		board := boards[0]
+		depth := depths[0]
+		boards = boards[1:]
+		depths = depths[1:]
+		if visited[board.Zobrist] || ( depth > b.Analysisdepth ){
+			continue
+		}
+		visited[board.Zobrist] = true
+		maxweight := uint16(0)
+		for _, entry := range(bykey[board.Zobrist]){
+			if entry.Weight > maxweight{
+				maxweight = entry.Weight
+			}
+		}
+		fen := board.Tofen()
+		p := NewPosition(fen)
+		for _, entry := range(bykey[board.Zobrist]){
+			algeb := Polyglotalgeb(board, entry.Move)
+			if Hasmovegen(b.Variantkey) && !board.Islegal(algeb){
+				fmt.Println("skipping illegal polyglot move", algeb, fen)
+				continue
+			}
+			score := Polyglotscore(entry.Weight, maxweight)
+			p.Moves = append(p.Moves, BookMove{algeb, score, score, INFINITE_MINIMAX_DEPTH, 0, [3]int{}, nil})
+			newboard := board.copy()
+			newboard.Makealgebmove(algeb)
+			boards = append(boards, newboard)
+			depths = append(depths, depth + 1)
+		}
+		if len(p.Moves) == 0{
+			continue
+		}
+		stored, err := b.storenew(p)
+		if err != nil{
+			return numpos, err
+		}
+		if stored{
+			numpos++
+		}
+	}
+	return numpos, nil
+}
+
+// Importpolyglotfile imports a Polyglot .bin file into the book
+func (b Book) Importpolyglotfile(path string) error{
+	fmt.Println("importing", path, "into", b.Fullname())
+	f, err := os.Open(path)
+	if err != nil{
+		return err
+	}
+	defer f.Close()
+	entries, err := Readpolyglot(bufio.NewReader(f))
+	if err != nil{
+		return err
+	}
+	numpos, err := b.Importpolyglot(entries)
+	if err != nil{
+		return err
+	}
+	fmt.Println("importing done", b.Fullname(), "entries", len(entries), "positions", numpos)
+	return nil
+}
+
+// Exportpolyglotfile writes the book to a Polyglot .bin file
+func (b Book) Exportpolyglotfile(path string) error{
+	fmt.Println("exporting", b.Fullname(), "to", path)
+	f, err := os.Create(path)
+	if err != nil{
+		return err
+	}
+	w := bufio.NewWriter(f)
+	numentries, err := b.Exportpolyglot(w)
+	if err == nil{
+		err = w.Flush()
+	}
+	closeerr := f.Close()
+	if err != nil{
+		return err
+	}
+	fmt.Println("exporting done", b.Fullname(), "entries", numentries)
+	return closeerr
+}
+
+////////////////////////////////////////////////////////////////
@@ -0,0 +1,167 @@
+////////////////////////////////////////////////////////////////
+
+package abb
+
+////////////////////////////////////////////////////////////////
+
+import(
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////
+
+var SAN_REGEXP = regexp.MustCompile(`^([NBRQK])?([a-h])?([1-8])?x?([a-h][1-8])(=?([NBRQnbrq]))?$`)
+
+////////////////////////////////////////////////////////////////
+
+// movecandidates returns the moves the san of a move is disambiguated
+// against, legal moves for variants with move generation
+func (b Board) movecandidates() []string{
+	if Hasmovegen(b.Variantkey){
+		return b.LegalMoves()
+	}
+	return b.Pseudolegalmoves()
+}
+
+// ToSAN converts a uci move of the board to standard algebraic notation
+// with disambiguation and check or mate suffix
+func (b Board) ToSAN(algeb string) string{
+	fromi, fromj := Sqindeces(algeb[0:2])
+	toi, toj := Sqindeces(algeb[2:4])
+	p := b.Rep[index(fromi, fromj)]
+	target := b.Rep[index(toi, toj)]
+	buff := ""
+	if ( p.Kind == "k" ) && ( ( fromi - toi == 2 ) || ( toi - fromi == 2 ) ){
+		buff = "O-O"
+		if toi < fromi{
+			buff = "O-O-O"
+		}
+	}else if p.Kind == "p"{
+		if fromi != toi{
+			// captures, en passant included
+			buff = algeb[0:1] + "x"
+		}
+		buff += algeb[2:4]
+		if len(algeb) > 4{
+			buff += "=" + strings.ToUpper(algeb[4:5])
+		}
+	}else{
+		buff = strings.ToUpper(p.Kind)
+		samefile := false
+		samerank := false
+		ambiguous := false
+		for _, other := range(b.movecandidates()){
+			if ( other == algeb ) || ( other[2:4] != algeb[2:4] ) || ( other[0:2] == algeb[0:2] ){
+				continue
+			}
+			otheri, otherj := Sqindeces(other[0:2])
+			if b.Rep[index(otheri, otherj)].Kind != p.Kind{
+				continue
+			}
+			ambiguous = true
+			if otheri == fromi{
+				samefile = true
+			}
+			if otherj == fromj{
+				samerank = true
+			}
+		}
+		if ambiguous{
+			if !samefile{
+				buff += algeb[0:1]
+			}else if !samerank{
+				buff += algeb[1:2]
+			}else{
+				buff += algeb[0:2]
+			}
+		}
+		if target.Kind != "-"{
+			buff += "x"
+		}
+		buff += algeb[2:4]
+	}
+	return buff + b.sansuffix(algeb)
+}
+
+// ParseSAN converts a move in standard algebraic notation to a uci move
+// of the board, check suffixes and annotations are ignored
+func (b Board) ParseSAN(san string) (string, error){
+	stripped := strings.TrimRight(san, "+#!?")
+	candidates := b.movecandidates()
+	kingi, kingj := -1, -1
+	if kingindex := b.Kingindex(b.Turn()); kingindex >= 0{
+		kingi, kingj = kingindex % 8, kingindex / 8
+	}
+	switch strings.Replace(stripped, "0", "O", -1){
+	case "O-O", "O-O-O":
+		toi := 6
+		if len(stripped) > 3{
+			toi = 2
+		}
+		for _, algeb := range(candidates){
+			if algeb == ijalgeb(kingi, kingj) + ijalgeb(toi, kingj){
+				return algeb, nil
+			}
+		}
+		return "", fmt.Errorf("illegal castling %q", san)
+	}
+	parts := SAN_REGEXP.FindStringSubmatch(stripped)
+	if parts == nil{
+		return "", fmt.Errorf("invalid san %q", san)
+	}
+	kind := strings.ToLower(parts[1])
+	if kind == ""{
+		kind = "p"
+	}
+	promotion := strings.ToLower(parts[6])
+	matches := []string{}
+	for _, algeb := range(candidates){
+		fromi, fromj := Sqindeces(algeb[0:2])
+		toi, _ := Sqindeces(algeb[2:4])
+		if ( b.Rep[index(fromi, fromj)].Kind != kind ) || ( algeb[2:4] != parts[4] ){
+			continue
+		}
+		if ( ( parts[2] != "" ) && ( algeb[0:1] != parts[2] ) ) || ( ( parts[3] != "" ) && ( algeb[1:2] != parts[3] ) ){
+			continue
+		}
+		if algeb[4:] != promotion{
+			continue
+		}
+		// castling is only written as O-O
+		if ( kind == "k" ) && ( ( fromi - toi == 2 ) || ( toi - fromi == 2 ) ){
+			continue
+		}
+		matches = append(matches, algeb)
+	}
+	if len(matches) == 0{
+		return "", fmt.Errorf("illegal san %q", san)
+	}
+	if len(matches) > 1{
+		return "", fmt.Errorf("ambiguous san %q : %v", san, matches)
+	}
+	return matches[0], nil
+}
+
+// sansuffix returns the check or mate suffix of a move, in atomic
+// exploding the opponent king mates
+func (b Board) sansuffix(algeb string) string{
+	if !Hasmovegen(b.Variantkey){
+		return ""
+	}
+	newboard := b.copy()
+	newboard.Makealgebmove(algeb)
+	if newboard.Variantend(){
+		return "#"
+	}
+	if !newboard.Incheck(){
+		return ""
+	}
+	if len(newboard.LegalMoves()) == 0{
+		return "#"
+	}
+	return "+"
+}
+
+////////////////////////////////////////////////////////////////
@@ -0,0 +1,216 @@
+////////////////////////////////////////////////////////////////
+
+package abb
+
+////////////////////////////////////////////////////////////////
+
+import(
+	"fmt"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////
+
+// Booklet is one shard of a book, holding position blobs keyed by posid,
+// or all its positions packed if it is a binary booklet
+type Booklet struct{
+	Id string
+	Positions map[string]string
+	Packed []byte
+}
+
+// BookStore is the storage backend a book is persisted to
+type BookStore interface{
+	// Listbooks returns the ids of all stored books
+	Listbooks() ([]string, error)
+	// Storebook stores the book metadata under the book id
+	Storebook(id string, meta map[string]interface{}) error
+	// Loadbook returns the stored metadata of a book, nil if the book is not stored
+	Loadbook(id string) (map[string]interface{}, error)
+	// Updatefields updates the given metadata fields of a stored book
+	Updatefields(id string, fields map[string]interface{}) error
+	// Loadbooklets returns all booklets of a book
+	Loadbooklets(id string) ([]Booklet, error)
+	// Savebooklets stores the given booklets of a book, replacing existing ones with the same id
+	Savebooklets(id string, booklets []Booklet) error
+	// Close releases the resources held by the store
+	Close() error
+}
+
+////////////////////////////////////////////////////////////////
+
+func Listbooks(store BookStore) error{
+	ids, err := store.Listbooks()
+	if err != nil{
+		return err
+	}
+	fmt.Println("list of books")
+	for _, id := range(ids){
+		fmt.Println("*", id)
+	}
+	return nil
+}
+
+func StoreBook(b Book) error{
+	return b.Bookstore.Storebook(b.Id(), b.Serialize())
+}
+
+func Updatebookfield(b Book, key string, value string) error{
+	return b.Bookstore.Updatefields(b.Id(), map[string]interface{}{
+		key: value,
+	})
+}
+
+// Copybook copies the book metadata and all booklets of the book as they
+// are stored in its store to another store, keeping the booklet sharding
+func Copybook(b Book, to BookStore) error{
+	fmt.Println("copying", b.Fullname())
+	booklets, err := b.Bookstore.Loadbooklets(b.Id())
+	if err != nil{
+		return err
+	}
+	meta, err := b.Bookstore.Loadbook(b.Id())
+	if err != nil{
+		return err
+	}
+	if meta == nil{
+		return fmt.Errorf("%s is not stored", b.Fullname())
+	}
+	err = to.Storebook(b.Id(), meta)
+	if err != nil{
+		return err
+	}
+	err = to.Savebooklets(b.Id(), booklets)
+	if err != nil{
+		return err
+	}
+	fmt.Println("copying done", b.Fullname(), "booklets", len(booklets))
+	return nil
+}
+
+func Synccache(b *Book) error{
+	start := time.Now()
+	fmt.Println(SEP)
+	fmt.Println("syncing cache", b.Fullname())
+	fmt.Println(SEP)
+	booklets, err := b.Bookstore.Loadbooklets(b.Id())
+	if err != nil{
+		return err
+	}
+	poscache := make(map[string]BookPosition)
+	numpos := 0
+	grandtotalblobsize := 0
+	maxnumbpos := 0
+	maxtotalblobsize := 0
+	for _, booklet := range(booklets){
+		positions, err := Bookletpositions(booklet)
+		if err != nil{
+			return err
+		}
+		numbpos := len(positions)
+		totalblobsize := Bookletsize(booklet)
+		for _, p := range(positions){
+			key, err := b.Poskeyof(p.Fen)
+			if err != nil{
+				return fmt.Errorf("booklet %s : %v", booklet.Id, err)
+			}
+			poscache[key] = p
+		}
+		numpos += numbpos
+		grandtotalblobsize += totalblobsize
+		fmt.Println(booklet.Id, numbpos, totalblobsize)
+		if numbpos > maxnumbpos{
+			maxnumbpos = numbpos
+		}
+		if totalblobsize > maxtotalblobsize{
+			maxtotalblobsize = totalblobsize
+		}
+	}
+	unlock := b.lockcache()
+	b.Poscache = poscache
+	unlock()
+	elapsed := time.Since(start)
+	fmt.Println("syncing cache done", b.Fullname(), "positions", numpos, "took", elapsed, "average blob size", grandtotalblobsize / (numpos+1), "max positions per booklet", maxnumbpos, "max total blobsize", maxtotalblobsize)
+	return b.Bookstore.Updatefields(b.Id(), map[string]interface{}{
+		"numpos": numpos,
+		"maxnumbpos": maxnumbpos,
+		"maxtotalblobsize": maxtotalblobsize,
+		"lastsync": Nowutcunixdate(),
+	})
+}
+
+func Uploadcache(b Book) error{
+	start := time.Now()
+	fmt.Println(SEP)
+	fmt.Println("uploading cache", b.Fullname())
+	fmt.Println(SEP)
+	packed := ( b.Bookletencoding == BOOKLET_BINARY ) || ( b.Bookletencoding == BOOKLET_COMPRESSED )
+	numpos := 0
+	maxblobsize := 0
+	booklets := make(map[string]Booklet)
+	bookletpositions := make(map[string][]BookPosition)
+	var keyerr error
+	unlock := b.rlockcache()
+	for _, p := range(b.Poscache){
+		bid := b.Bookletid(p.Fen)
+		numpos++
+		if packed{
+			bookletpositions[bid] = append(bookletpositions[bid], p)
+			continue
+		}
+		booklet, ok := booklets[bid]
+		if !ok{
+			booklet = Booklet{
+				Id: bid,
+				Positions: make(map[string]string),
+			}
+			booklets[bid] = booklet
+		}
+		key, err := b.Poskeyof(p.Fen)
+		if err != nil{
+			keyerr = err
+			break
+		}
+		blob := p.Serialize()["blob"].(string)
+		booklet.Positions[key] = blob
+		if len(blob) > maxblobsize{
+			maxblobsize = len(blob)
+		}
+	}
+	unlock()
+	if keyerr != nil{
+		return keyerr
+	}
+	// binary booklets report the size of the largest packed booklet
+	for bid, positions := range(bookletpositions){
+		booklet, err := Packbooklet(bid, positions, b.Bookletencoding == BOOKLET_COMPRESSED)
+		if err != nil{
+			return err
+		}
+		booklets[bid] = booklet
+		if size := Bookletsize(booklet); size > maxblobsize{
+			maxblobsize = size
+		}
+	}
+	bookletlist := make([]Booklet, 0)
+	for bookletid, booklet := range(booklets){
+		fmt.Println("uploading", bookletid, b.Fullname())
+		if size := Bookletsize(booklet); size > BOOKLET_MAX_SIZE{
+			fmt.Println("warning : booklet", bookletid, "has", size, "bytes, more than", BOOKLET_MAX_SIZE)
+		}
+		bookletlist = append(bookletlist, booklet)
+	}
+	err := b.Bookstore.Savebooklets(b.Id(), bookletlist)
+	if err != nil{
+		return err
+	}
+	elapsed := time.Since(start)
+	fmt.Println("uploading cache done", b.Fullname(), "positions", numpos, "max blobsize", maxblobsize, "took", elapsed)
+	return b.Bookstore.Updatefields(b.Id(), map[string]interface{}{
+		"numpos": numpos,
+		"maxblobsize": maxblobsize,
+		"lastupload": Nowutcunixdate(),
+	})
+}
+
+////////////////////////////////////////////////////////////////
@@ -6,13 +6,18 @@ package abb
 
 import (	
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"os/exec"
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"text/scanner"
 	"math"
 	"math/rand"
@@ -23,7 +28,7 @@ import (
 
 // constants for result filtering
 const (
-	HighestDepthOnly   uint = 1 << iota // only return the highest depth results
+	HighestDepthOnly   uint = 1 << iota // only return the deepest result of each line
 	IncludeUpperbounds uint = 1 << iota // include upperbound results
 	IncludeLowerbounds uint = 1 << iota // include lowerbound results
 )
@@ -60,6 +65,9 @@ type ScoreResult struct {
 	Upperbound     bool     // true if reported as upperbound
 	Score          int      // score centipawns or mate in X if Mate is true
 	Mate           bool     // whether this move results in forced mate
+	WDL            [3]int   // win, draw and loss permille, zero if not reported
+	Hashfull       int      // hash usage permille
+	TBHits         int      // tablebase hits
 	BestMoves      []string // best line for this result
 }
 
@@ -69,6 +77,7 @@ type Results struct {
 	BestMove string
 	results  map[scoreKey]ScoreResult
 	Results  []ScoreResult
+	Strings  []string // info string lines of the engine
 }
 
 func (r Results) String() string {
@@ -80,76 +89,370 @@ func (r Results) String() string {
 // a chess engine executable. Engines should be created with
 // a call to NewEngine(/path/to/executable)
 type Engine struct {
-	cmd    *exec.Cmd
-	stdout *bufio.Reader
-	stdin  *bufio.Writer
+	cmd     *exec.Cmd
+	pipe    io.Closer
+	stdout  *bufio.Reader
+	stdin   *bufio.Writer
+	Name    string                  // id name of the engine
+	Author  string                  // id author of the engine
+	Options map[string]EngineOption // advertised options by lower case name
+	options *Options                // options last sent to the engine
+	spawn   func() (*exec.Cmd, io.WriteCloser, io.Reader, error)
+	exited  chan struct{} // closed when the engine process exits
+}
+
+// EngineOption is an option advertised by the engine in the uci handshake
+type EngineOption struct {
+	Name    string
+	Type    string   // check, spin, combo, button or string
+	Default string
+	Min     int      // range of spin options
+	Max     int
+	Vars    []string // values of combo options
+}
+
+// OptionError is returned for an option value the engine does not accept,
+// setting the option again fails the same way
+type OptionError struct {
+	Name   string
+	Value  string
+	Reason string
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("option %s value %s %s", e.Name, e.Value, e.Reason)
 }
 
 // NewEngine returns an Engine it has spun up
 // and connected communication to
 func NewEngine(path string) (*Engine, error) {
 	eng := Engine{}
-	eng.cmd = exec.Command(path)
-	stdin, err := eng.cmd.StdinPipe()
-	if err != nil {
-		return nil, err
+	eng.spawn = func() (*exec.Cmd, io.WriteCloser, io.Reader, error) {
+		cmd := exec.Command(path)
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, nil, nil, err
+		}
+		return cmd, stdin, stdout, nil
 	}
-	stdout, err := eng.cmd.StdoutPipe()
-	if err != nil {
+	if err := eng.start(); err != nil {
 		return nil, err
 	}
-	if err := eng.cmd.Start(); err != nil {
+	return &eng, nil
+}
+
+// newPipeEngine returns an Engine talking to an engine over the
+// pipes returned by spawn instead of a spawned process
+func newPipeEngine(spawn func() (io.WriteCloser, io.Reader)) (*Engine, error) {
+	eng := Engine{}
+	eng.spawn = func() (*exec.Cmd, io.WriteCloser, io.Reader, error) {
+		stdin, stdout := spawn()
+		return nil, stdin, stdout, nil
+	}
+	if err := eng.start(); err != nil {
 		return nil, err
 	}
+	return &eng, nil
+}
+
+// start spawns the engine, watches the process for exit and does the handshake
+func (eng *Engine) start() error {
+	cmd, stdin, stdout, err := eng.spawn()
+	if err != nil {
+		return err
+	}
+	eng.cmd = cmd
+	eng.pipe = stdin
 	eng.stdin = bufio.NewWriter(stdin)
 	eng.stdout = bufio.NewReader(stdout)
-	return &eng, nil
+	eng.exited = make(chan struct{})
+	if cmd != nil {
+		go func(exited chan struct{}) {
+			cmd.Wait()
+			close(exited)
+		}(eng.exited)
+	}
+	if err := eng.handshake(); err != nil {
+		eng.Close()
+		return err
+	}
+	return nil
+}
+
+// Exited tells whether the engine process has exited
+func (eng *Engine) Exited() bool {
+	select {
+	case <-eng.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// Failed tells whether the error means that the engine itself failed, its
+// process exited, its pipes broke or it did not stop, so that restarting it
+// may help, option errors and timeouts are not engine failures
+func (eng *Engine) Failed(err error) bool {
+	if err == nil {
+		return false
+	}
+	if eng.cmd != nil && eng.Exited() {
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, os.ErrClosed) || errors.Is(err, syscall.EPIPE) || errors.Is(err, ErrEngineNotStopped) ||
+		errors.Is(err, ErrEngineKilled)
+}
+
+// Restart stops the engine and starts it again with the options last set
+func (eng *Engine) Restart() error {
+	eng.Close()
+	opt := eng.options
+	eng.options = nil
+	err := eng.start()
+	if err != nil {
+		return fmt.Errorf("engine restart failed: %w", err)
+	}
+	if opt != nil {
+		return eng.SetOptions(*opt)
+	}
+	return nil
 }
 
-// SetOptions sends setoption commands to the Engine
-// for the values set in the Options record passed in
+// parseOption parses an option line of the uci handshake,
+// names may contain spaces
+func parseOption(line string) (EngineOption, error) {
+	opt := EngineOption{}
+	fields := strings.Fields(line)
+	key := ""
+	values := map[string][]string{}
+	for _, field := range fields[1:] {
+		switch field {
+		case "name", "type", "default", "min", "max":
+			key = field
+			values[key] = []string{}
+			continue
+		case "var":
+			opt.Vars = append(opt.Vars, "")
+			key = field
+			continue
+		}
+		if key == "var" {
+			last := len(opt.Vars) - 1
+			opt.Vars[last] = strings.TrimSpace(opt.Vars[last] + " " + field)
+			continue
+		}
+		if key == "" {
+			return opt, fmt.Errorf("invalid option line %q", line)
+		}
+		values[key] = append(values[key], field)
+	}
+	opt.Name = strings.Join(values["name"], " ")
+	opt.Type = strings.Join(values["type"], " ")
+	opt.Default = strings.Join(values["default"], " ")
+	if opt.Name == "" || opt.Type == "" {
+		return opt, fmt.Errorf("invalid option line %q", line)
+	}
+	var err error
+	if len(values["min"]) > 0 {
+		opt.Min, err = strconv.Atoi(values["min"][0])
+		if err != nil {
+			return opt, fmt.Errorf("invalid option line %q", line)
+		}
+	}
+	if len(values["max"]) > 0 {
+		opt.Max, err = strconv.Atoi(values["max"][0])
+		if err != nil {
+			return opt, fmt.Errorf("invalid option line %q", line)
+		}
+	}
+	return opt, nil
+}
+
+func (eng *Engine) send(cmd string) error {
+	_, err := eng.stdin.WriteString(cmd + "\n")
+	if err != nil {
+		return err
+	}
+	return eng.stdin.Flush()
+}
+
+// readUntil reads engine output up to the line with the given first word
+// and returns the lines read before it
+func (eng *Engine) readUntil(word string) ([]string, error) {
+	lines := []string{}
+	for {
+		line, err := eng.stdout.ReadString('\n')
+		if err != nil {
+			return lines, err
+		}
+		line = strings.TrimSpace(line)
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == word {
+			return lines, nil
+		}
+		lines = append(lines, line)
+	}
+}
+
+// handshake sends uci, reads the engine id and the advertised options
+// up to uciok and waits for the engine to be ready
+func (eng *Engine) handshake() error {
+	err := eng.send("uci")
+	if err != nil {
+		return err
+	}
+	lines, err := eng.readUntil("uciok")
+	if err != nil {
+		return fmt.Errorf("uci handshake failed: %v", err)
+	}
+	eng.Options = make(map[string]EngineOption)
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "id name "):
+			eng.Name = strings.TrimPrefix(line, "id name ")
+		case strings.HasPrefix(line, "id author "):
+			eng.Author = strings.TrimPrefix(line, "id author ")
+		case strings.HasPrefix(line, "option "):
+			opt, err := parseOption(line)
+			if err != nil {
+				return err
+			}
+			eng.Options[strings.ToLower(opt.Name)] = opt
+		}
+	}
+	return eng.IsReady()
+}
+
+// IsReady sends isready and waits for readyok
+func (eng *Engine) IsReady() error {
+	err := eng.send("isready")
+	if err != nil {
+		return err
+	}
+	_, err = eng.readUntil("readyok")
+	return err
+}
+
+// NewGame sends ucinewgame and waits for the engine to be ready
+func (eng *Engine) NewGame() error {
+	err := eng.send("ucinewgame")
+	if err != nil {
+		return err
+	}
+	return eng.IsReady()
+}
+
+// HasOption tells whether the engine advertised the option
+func (eng *Engine) HasOption(name string) bool {
+	_, ok := eng.Options[strings.ToLower(name)]
+	return ok
+}
+
+// SetOptions sends setoption commands to the Engine for the values set
+// in the Options record passed in, validated against the advertised
+// options, options are only sent when they changed, values the engine
+// does not accept are returned as an OptionError
 func (eng *Engine) SetOptions(opt Options) error {
+	if eng.options != nil && *eng.options == opt {
+		return nil
+	}
+	// the options are unknown until all of them are sent
+	eng.options = nil
 	var err error
-	err = eng.sendOption("UCI_Variant", opt.UCI_Variant)
+	variant := opt.UCI_Variant
+	if variant == "standard" {
+		variant = "chess"
+	}
+	if eng.HasOption("UCI_Variant") && variant != "" {
+		err = eng.sendOption("UCI_Variant", variant)
+	} else if variant != "" && variant != "chess" {
+		err = &OptionError{"UCI_Variant", variant, "not supported by engine " + eng.Name}
+	}
 	if err != nil {
-		fmt.Println("could not send variant uci option", opt.UCI_Variant)
+		return err
 	}
 	if opt.MultiPV > 0 {
-		err = eng.sendOption("multipv", opt.MultiPV)
+		err = eng.sendOption("MultiPV", opt.MultiPV)
 		if err != nil {
 			return err
 		}
 	}
 	if opt.Hash > 0 {
-		err = eng.sendOption("hash", opt.Hash)
+		err = eng.sendOption("Hash", opt.Hash)
 		if err != nil {
 			return err
 		}
 	}
 	if opt.Threads > 0 {
-		err = eng.sendOption("threads", opt.Threads)
+		err = eng.sendOption("Threads", opt.Threads)
 		if err != nil {
 			return err
 		}
 	}
-	err = eng.sendOption("ownbook", opt.OwnBook)
-	if err != nil {
-		return err
+	// switches are only sent if the engine knows them
+	if eng.HasOption("OwnBook") {
+		err = eng.sendOption("OwnBook", opt.OwnBook)
+		if err != nil {
+			return err
+		}
+	}
+	if eng.HasOption("Ponder") {
+		err = eng.sendOption("Ponder", opt.Ponder)
+		if err != nil {
+			return err
+		}
 	}
-	err = eng.sendOption("ponder", opt.Ponder)
+	if eng.HasOption("UCI_ShowWDL") {
+		err = eng.sendOption("UCI_ShowWDL", true)
+		if err != nil {
+			return err
+		}
+	}
+	err = eng.NewGame()
 	if err != nil {
 		return err
 	}
-	return err
+	eng.options = &opt
+	return nil
 }
 
+// sendOption validates the value against the advertised option and sends it
 func (eng *Engine) sendOption(name string, value interface{}) error {
-	_, err := eng.stdin.WriteString(fmt.Sprintf("setoption name %s value %v\n", name, value))
-	if err != nil {
-		return err
+	strvalue := fmt.Sprintf("%v", value)
+	engopt, ok := eng.Options[strings.ToLower(name)]
+	if !ok {
+		return &OptionError{name, strvalue, "not advertised by engine " + eng.Name}
 	}
-	err = eng.stdin.Flush()
-	return err
+	switch engopt.Type {
+	case "spin":
+		intvalue, err := strconv.Atoi(strvalue)
+		if err != nil || intvalue < engopt.Min || intvalue > engopt.Max {
+			return &OptionError{engopt.Name, strvalue, fmt.Sprintf("not in range %d to %d", engopt.Min, engopt.Max)}
+		}
+	case "check":
+		if strvalue != "true" && strvalue != "false" {
+			return &OptionError{engopt.Name, strvalue, "is not a boolean"}
+		}
+	case "combo":
+		found := false
+		for _, v := range engopt.Vars {
+			if strings.EqualFold(v, strvalue) {
+				found = true
+			}
+		}
+		if !found {
+			return &OptionError{engopt.Name, strvalue, fmt.Sprintf("not one of %v", engopt.Vars)}
+		}
+	}
+	return eng.send(fmt.Sprintf("setoption name %s value %s", engopt.Name, strvalue))
 }
 
 // SetFEN takes a FEN string and tells the engine to set the position
@@ -162,69 +465,204 @@ func (eng *Engine) SetFEN(fen string) error {
 	return err
 }
 
+// SearchLimits are the limits of a search, zero limits are not sent
+type SearchLimits struct {
+	Depth    int // plies
+	Nodes    int // nodes searched
+	Movetime int // milliseconds
+}
+
+// ENGINE_STOP_TIMEOUT is how long a stopped engine may take to send bestmove
+var ENGINE_STOP_TIMEOUT = 10 * time.Second
+
+// ErrEngineNotStopped is returned when a stopped engine does not send
+// bestmove in time, the engine is killed and has to be restarted
+var ErrEngineNotStopped = errors.New("engine did not stop")
+
+// ErrEngineKilled is returned when a search is abandoned because the engine
+// could not be stopped or sent invalid output, the engine is killed so that
+// none of its output is read as the answer to a later command, and has to be
+// restarted
+var ErrEngineKilled = errors.New("engine killed")
+
 // Go can use search moves, depth and time to move as filter  for the results being returned.
 // see http://wbec-ridderkerk.nl/html/UCIProtocol.html
 func (eng *Engine) Go(depth int, searchmoves string, movetime int, resultOpts ...uint) (*Results, error) {
+	return eng.GoContext(context.Background(), SearchLimits{Depth: depth, Movetime: movetime}, searchmoves, resultOpts...)
+}
+
+// GoContext searches within the limits until the engine sends bestmove,
+// if the context is done first the engine is stopped and its output is
+// drained up to bestmove, the results found so far are returned along
+// with the context error, an engine that does not stop or sends invalid
+// output is killed
+func (eng *Engine) GoContext(ctx context.Context, limits SearchLimits, searchmoves string, resultOpts ...uint) (*Results, error) {
 	res := Results{}
 	resultOpt := uint(0)
 	if len(resultOpts) == 1 {
 		resultOpt = resultOpts[0]
 	}
-	goCmd := "go "
+	goCmd := "go"
 
-	if depth != 0 {
-		goCmd += fmt.Sprintf("depth %d", depth)
+	if limits.Depth != 0 {
+		goCmd += fmt.Sprintf(" depth %d", limits.Depth)
+	}
+	if limits.Nodes != 0 {
+		goCmd += fmt.Sprintf(" nodes %d", limits.Nodes)
 	}
 	if searchmoves != "" {
 		goCmd += fmt.Sprintf(" searchmoves %s", searchmoves)
 	}
-	if movetime != 0 {
-		goCmd += fmt.Sprintf(" movetime %d", movetime)
+	if limits.Movetime != 0 {
+		goCmd += fmt.Sprintf(" movetime %d", limits.Movetime)
 	}
-	goCmd += "\n"
-	_, err := eng.stdin.WriteString(goCmd)
-
-	if err != nil {
-		return nil, err
+	if limits.Depth == 0 && limits.Nodes == 0 && limits.Movetime == 0 {
+		goCmd += " infinite"
 	}
-	err = eng.stdin.Flush()
+	err := eng.send(goCmd)
 	if err != nil {
 		return nil, err
 	}
+
+	type readLine struct {
+		line string
+		err  error
+	}
+	lines := make(chan readLine)
+	quit := make(chan struct{})
+	finished := make(chan struct{})
+	defer close(quit)
+	go func(stdout *bufio.Reader) {
+		defer close(finished)
+		for {
+			line, err := stdout.ReadString('\n')
+			select {
+			case lines <- readLine{line, err}:
+			case <-quit:
+				return
+			}
+			if err != nil || strings.HasPrefix(line, "bestmove") {
+				return
+			}
+		}
+	}(eng.stdout)
+
+	// killing the engine ends the read of the reader goroutine, which
+	// is waited for so that nobody else reads the output concurrently
+	kill := func(err error) (*Results, error) {
+		eng.kill()
+		for {
+			select {
+			case <-lines:
+			case <-finished:
+				return nil, err
+			}
+		}
+	}
+
+	done := ctx.Done()
+	var stopped chan error
+	// the engine is not written to by others before stop is sent
+	defer func() {
+		if stopped != nil {
+			<-stopped
+		}
+	}()
+	var stopTimeout <-chan time.Time
+	var ctxErr error
 	for {
-		line, err := eng.stdout.ReadString('\n')
-		if err != nil {
-			return nil, err
+		var rl readLine
+		select {
+		case rl = <-lines:
+		case <-done:
+			ctxErr = ctx.Err()
+			done = nil
+			// stop is sent while the output is read, the engine may be blocked writing it
+			stopped = make(chan error, 1)
+			go func(stdin *bufio.Writer) {
+				_, err := stdin.WriteString("stop\n")
+				if err == nil {
+					err = stdin.Flush()
+				}
+				stopped <- err
+			}(eng.stdin)
+			stopTimeout = time.After(ENGINE_STOP_TIMEOUT)
+			continue
+		case err := <-stopped:
+			stopped = nil
+			if err != nil {
+				return kill(fmt.Errorf("%w: stop failed: %v", ErrEngineKilled, err))
+			}
+			continue
+		case <-stopTimeout:
+			return kill(fmt.Errorf("%w: %v", ErrEngineNotStopped, ctxErr))
+		}
+		if rl.err != nil {
+			return nil, rl.err
 		}
-		line = strings.Trim(line, "\n")
+		line := strings.Trim(rl.line, "\n")
 		if strings.HasPrefix(line, "bestmove") {
 			dummy := ""
 			_, err := fmt.Sscanf(line, "%s %s", &dummy, &res.BestMove)
 			if err != nil {
-				return nil, err
+				return kill(fmt.Errorf("%w: invalid line %q: %v", ErrEngineKilled, line, err))
 			}
 			break
 		}
 
-		err = res.addLineToResults(line)
+		err := res.addLineToResults(line)
 		if err != nil {
-			return nil, err
+			return kill(fmt.Errorf("%w: invalid line %q: %v", ErrEngineKilled, line, err))
 		}
 	}
+	deepest := map[int]ScoreResult{}
 	for _, v := range res.results {
-		if resultOpt&HighestDepthOnly != 0 && v.Depth != depth {
-			continue
-		}
 		if resultOpt&IncludeUpperbounds == 0 && v.Upperbound {
 			continue
 		}
 		if resultOpt&IncludeLowerbounds == 0 && v.Lowerbound {
 			continue
 		}
+		if resultOpt&HighestDepthOnly != 0 {
+			if d, ok := deepest[v.MultiPV]; !ok || v.Depth > d.Depth {
+				deepest[v.MultiPV] = v
+			}
+			continue
+		}
 		res.Results = append(res.Results, v)
 	}
+	if resultOpt&HighestDepthOnly != 0 {
+		res.Results = deepestLines(deepest)
+		return &res, ctxErr
+	}
 	sort.Sort(byDepth(res.Results))
-	return &res, nil
+	return &res, ctxErr
+}
+
+// deepestLines returns the deepest results of the lines sorted by MultiPV,
+// a search stopped early leaves the lines it did not search again at a lower
+// depth, such a line is dropped if its move is on a deeper line
+func deepestLines(deepest map[int]ScoreResult) []ScoreResult {
+	results := []ScoreResult{}
+	for _, v := range deepest {
+		results = append(results, v)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].MultiPV < results[j].MultiPV
+	})
+	lines := []ScoreResult{}
+	for _, v := range results {
+		duplicate := false
+		for _, w := range results {
+			if len(v.BestMoves) > 0 && len(w.BestMoves) > 0 && v.BestMoves[0] == w.BestMoves[0] && w.Depth > v.Depth {
+				duplicate = true
+			}
+		}
+		if !duplicate {
+			lines = append(lines, v)
+		}
+	}
+	return lines
 }
 
 // GoDepth takes a depth and an optional uint flag that configures filters
@@ -255,6 +693,10 @@ func (res *Results) addLineToResults(line string) error {
 	if !strings.HasPrefix(line, "info") {
 		return nil
 	}
+	if strings.HasPrefix(line, "info string ") {
+		res.Strings = append(res.Strings, strings.TrimPrefix(line, "info string "))
+		return nil
+	}
 	//log.Println(line)
 	rd := strings.NewReader(line)
 	s := scanner.Scanner{}
@@ -327,6 +769,26 @@ func (res *Results) addLineToResults(line string) error {
 				return err
 			}
 			r.Score = r.Score * negative
+		case "wdl":
+			for i := range r.WDL {
+				s.Scan()
+				r.WDL[i], err = strconv.Atoi(s.TokenText())
+				if err != nil {
+					return err
+				}
+			}
+		case "hashfull":
+			s.Scan()
+			r.Hashfull, err = strconv.Atoi(s.TokenText())
+			if err != nil {
+				return err
+			}
+		case "tbhits":
+			s.Scan()
+			r.TBHits, err = strconv.Atoi(s.TokenText())
+			if err != nil {
+				return err
+			}
 		case "pv":
 			for s.Scan() != scanner.EOF {
 				r.BestMoves = append(r.BestMoves, s.TokenText())
@@ -347,22 +809,37 @@ func (res *Results) addLineToResults(line string) error {
 	return nil
 }
 
+// Close stops the engine and closes its pipes, an engine process that
+// already exited is not told to stop but its pipes are still released
 func (eng *Engine) Close() {
-	_, err := eng.stdin.WriteString("stop\n")
-	if err != nil {
-		log.Println("failed to stop engine:", err)
-	}
-	eng.stdin.Flush()
-	err = eng.cmd.Process.Kill()
-	if err != nil {
-		log.Println("failed to kill engine:", err)
+	if eng.cmd == nil || !eng.Exited() {
+		err := eng.send("stop")
+		if err == nil {
+			err = eng.send("quit")
+		}
+		if err != nil {
+			log.Println("failed to stop engine:", err)
+		}
 	}
-	eng.cmd.Wait()
+	eng.kill()
 }
 
-////////////////////////////////////////////////////////////////
-
-var eng *Engine
+// kill closes the pipes of the engine and kills its process without
+// writing to it, the process is waited for by the goroutine watching
+// it, which also closes the stdout pipe
+func (eng *Engine) kill() {
+	eng.pipe.Close()
+	if eng.cmd == nil {
+		return
+	}
+	if !eng.Exited() {
+		err := eng.cmd.Process.Kill()
+		if err != nil && !errors.Is(err, os.ErrProcessDone) {
+			log.Println("failed to kill engine:", err)
+		}
+	}
+	<-eng.exited
+}
 
 ////////////////////////////////////////////////////////////////
 
@@ -371,33 +848,48 @@ const MATE_SCORE = 9000
 
 ////////////////////////////////////////////////////////////////
 
-func init(){
-	fmt.Println("--> initializing engine")
-	eng, _ = NewEngine("engines/stockfish9")
-	fmt.Println("--> engine initialized")
-}
-
-////////////////////////////////////////////////////////////////
-
-func Analyze(fen string, depth int, variantkey string) BookPosition {
-	eng.SetOptions(Options{
-		UCI_Variant:variantkey,
-		Hash:64,
-		Threads:1,
-		MultiPV:250,		
-	})
+// Analyze runs a multipv analysis of the position within the limits
+// with the given options and returns the position with the scored moves,
+// if the context is done the moves found so far are returned if any, if
+// the options cannot be set the position is not analyzed
+func (eng *Engine) Analyze(ctx context.Context, fen string, limits SearchLimits, opt Options) (BookPosition, error) {
+	p := NewPosition(fen)
+	err := eng.SetOptions(opt)
+	if err != nil {
+		return p, err
+	}
 
-	eng.SetFEN(fen)
+	err = eng.SetFEN(fen)
+	if err != nil {
+		return p, err
+	}
 	
 	resultOpts := HighestDepthOnly
-	results, _ := eng.GoDepth(depth, resultOpts)
+	results, err := eng.GoContext(ctx, limits, "", resultOpts)
+	if results != nil && len(results.Results) > 0 && ctx.Err() != nil {
+		fmt.Println("analysis stopped", ctx.Err(), "at depth", results.Results[0].Depth)
+		err = nil
+	}
+	if err != nil {
+		if eng.cmd != nil && eng.Exited() {
+			err = fmt.Errorf("engine exited: %w", err)
+		}
+		return p, err
+	}
 
+	for _, str := range(results.Strings){
+		fmt.Println("engine", eng.Name, ":", str)
+	}
 	moves := results.Results
-	p := NewPosition(fen)
 	for _, move := range(moves){		
 		score := move.Score
 		depth := move.Depth
-		p.Enginedepth = depth
+		if len(move.BestMoves) == 0{
+			continue
+		}
+		if depth > p.Enginedepth{
+			p.Enginedepth = depth
+		}
 		if move.Mate{
 			if score < 0{
 				score = -INF_SCORE - score
@@ -412,11 +904,11 @@ func Analyze(fen string, depth int, variantkey string) BookPosition {
 			}
 		}		
 		algeb := move.BestMoves[0]
-		m := BookMove{algeb, score, score, INFINITE_MINIMAX_DEPTH, 0}
+		m := BookMove{algeb, score, score, INFINITE_MINIMAX_DEPTH, 0, move.WDL, move.BestMoves[1:]}
 		p.Moves = append(p.Moves, m)
 	}
 
-	return p
+	return p, nil
 }
 
 ////////////////////////////////////////////////////////////////
@@ -428,7 +920,7 @@ func (b Book) SelectRecursive(fen string, depth int, line []string, widthbonus i
 		return ""
 	}
 	p, ok := b.Getpos(fen)
-	if ok{
+	if ok && !p.Isimported(){
 		mli := p.Getmovelist().Items
 		mlilen := len(mli)
 		if mlilen == 0{
@@ -456,7 +948,11 @@ func (b Book) SelectRecursive(fen string, depth int, line []string, widthbonus i
 			fmt.Println("cutoff")
 			return ""
 		}
-		newfen := b.Makealgebmove(selmove.Algeb, fen)
+		newfen, err := b.Makealgebmove(selmove.Algeb, fen)
+		if err != nil{
+			fmt.Println("invalid book move", err)
+			return ""
+		}
 		return b.SelectRecursive(newfen, depth + 1, append(line, selmove.Algeb), widthbonus)
 	}else{
 		fmt.Println("selected", fen)
@@ -476,9 +972,17 @@ func (b Book) Addone() string{
 			fmt.Println("add one failed with widthbonus", widthbonus)
 		}else{
 			fmt.Println("analyzing", fen)
-			p := Analyze(fen, int(b.Enginedepth), b.Variantkey)
-			fmt.Println("storing", p.Posid())
-			b.StorePosition(p)
+			p, err := b.Analyze(fen)
+			if err != nil{
+				fmt.Println("analysis failed", err)
+				return ""
+			}
+			fmt.Println("storing", p.Fen)
+			err = b.StorePosition(p)
+			if err != nil{
+				fmt.Println("storing failed", err)
+				return ""
+			}
 			b.Updatefield("lastadd", Nowutcunixdate())
 			return fen
 		}	
@@ -496,8 +1000,7 @@ func (b *Book) Minimaxrecursive(board Board, line []string, posids []string, dep
 	if depth > maxdepth{		
 		return 2 * max, seldepth, nodes
 	}
-	fen := board.Tofen()
-	posid := Fen2posid(fen)
+	posid := b.Boardkey(board)
 	// repetition
 	for _, testposid := range posids{
 		if testposid == posid{
@@ -507,7 +1010,8 @@ func (b *Book) Minimaxrecursive(board Board, line []string, posids []string, dep
 	newposids := append(posids, posid)
 	// check if position is found
 	p, ok := b.Poscache[posid]	
-	if !ok{		
+	// imported positions have no engine evals
+	if !ok || p.Isimported(){		
 		return 2 * max, seldepth, nodes
 	}	
 	if depth > seldepth{
@@ -520,7 +1024,8 @@ func (b *Book) Minimaxrecursive(board Board, line []string, posids []string, dep
 		value := mi.Score		
 		haspv := 0
 		nodesnow := nodes
-		if ( mi.Score >= -cutoff ) && ( mi.Score <= cutoff ){
+		// corrupt moves are not followed and keep their score
+		if ( mi.Score >= -cutoff ) && ( mi.Score <= cutoff ) && ( board.Checkalgeb(algeb) == nil ){
 			newboard := board.copy()
 			newboard.Makealgebmove(algeb)
 			value, seldepth, nodes = b.Minimaxrecursive(newboard, append(line, algeb), newposids, depth + 1, maxdepth, seldepth, nodes, cutoff)			
@@ -555,13 +1060,20 @@ func (b *Book) Minimaxout(){
 	fmt.Println("minimaxing out", b.Fullname())	
 	fmt.Println(SEP)
 	board := NewBoard(b.Variantkey)
-	board.Setfromfen(b.Rootfen)
+	err := board.Setfromfen(b.Rootfen)
+	if err != nil{
+		fmt.Println("minimaxing failed", err)
+		return
+	}
+	// the minimax updates the cached moves in place
+	unlock := b.lockcache()
 	for _, p := range(b.Poscache){
 		for movei, _ := range(p.Moves){
 			p.Moves[movei].Minimaxdepth = INFINITE_MINIMAX_DEPTH
 		}
 	}
 	value, seldepth, nodes := b.Minimaxrecursive(board, []string{}, []string{}, 0, b.Analysisdepth, 0, 0, b.Cutoff)
+	unlock()
 	elapsed := time.Since(start)
 	fmt.Println("minimaxing done", b.Fullname(), -value, seldepth, nodes, "took", elapsed, "rate", float32(nodes) / float32(elapsed) * 1e9)	
 	b.Updatefield("lastminimax", Nowutcunixdate())
@@ -49,6 +49,12 @@ func Envintarray(key string, defaultvalue []int) []int{
 	return defaultvalue
 }
 
+// Hasenv tells whether the environment variable is set
+func Hasenv(key string) bool{
+	_, haskey := os.LookupEnv(key)
+	return haskey
+}
+
 func Envstr(key string, defaultvalue string) string{
 	valuestr, haskey := os.LookupEnv(key)
 	if haskey{
@@ -62,9 +68,13 @@ func Envstr(key string, defaultvalue string) string{
 func Fen2bookletindex(fen string, mod int) int{
 
 	parts := strings.Split(fen, " ")
+	// the clocks are not part of the position
+	for len(parts) < 6{
+		parts = append(parts, "")
+	}
 	parts[4] = "0"
 	parts[5] = "1"
-	fakefen := strings.Join(parts, " ")
+	fakefen := strings.Join(parts[:6], " ")
 
 	sum := 0
 	for i, c := range fakefen{
@@ -79,6 +89,9 @@ func Bookletid(fen string, mod int) string{
 
 func Fen2posid(fen string) string{	
 	parts := strings.Split(fen, " ")
+	for len(parts) < 4{
+		parts = append(parts, "")
+	}
 	rawfenparts := strings.Split(parts[0], "/")
 	rawfen := strings.Join(rawfenparts, "")
 	posid := rawfen + parts[1] + parts[2] + parts[3]
@@ -0,0 +1,111 @@
+////////////////////////////////////////////////////////////////
+
+package abb
+
+////////////////////////////////////////////////////////////////
+
+import(
+	"fmt"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////
+
+// zobrist keys use the Polyglot random numbers, so the key of a
+// position equals its Polyglot book key
+
+var ZOBRIST_PIECE_KINDS = map[string]int{"p": 0, "n": 1, "b": 2, "r": 3, "q": 4, "k": 5}
+
+const ZOBRIST_CASTLING_OFFSET = 768
+const ZOBRIST_EP_OFFSET = 772
+const ZOBRIST_TURN_OFFSET = 780
+
+////////////////////////////////////////////////////////////////
+
+func zobristpiece(sq int, p Piece) uint64{
+	kind, ok := ZOBRIST_PIECE_KINDS[p.Kind]
+	if !ok{
+		return 0
+	}
+	// black pieces come before white pieces, rows are counted from rank 1
+	return POLYGLOT_RANDOM[64 * ( 2 * kind + p.Color ) + 8 * ( 7 - sq / 8 ) + sq % 8]
+}
+
+func zobristcastling(castlefen string) uint64{
+	key := uint64(0)
+	for i, right := range([]string{"K", "Q", "k", "q"}){
+		if strings.Contains(castlefen, right){
+			key ^= POLYGLOT_RANDOM[ZOBRIST_CASTLING_OFFSET + i]
+		}
+	}
+	return key
+}
+
+func zobristturn(turnfen string) uint64{
+	if turnfen == "w"{
+		return POLYGLOT_RANDOM[ZOBRIST_TURN_OFFSET]
+	}
+	return 0
+}
+
+// zobristep returns the en passant key, which is only counted if a pawn
+// of the side to move stands next to the pawn that made the double push
+func (b Board) zobristep() uint64{
+	if ( len(b.Epfen) != 2 ) || ( b.Rep == nil ){
+		return 0
+	}
+	epi, epj := Sqindeces(b.Epfen)
+	pawnj := epj + 1
+	if b.Turn() == BLACK{
+		pawnj = epj - 1
+	}
+	for _, pi := range([]int{epi-1, epi+1}){
+		if ijok(pi, pawnj) && b.pieceat(pi, pawnj, "p", b.Turn()){
+			return POLYGLOT_RANDOM[ZOBRIST_EP_OFFSET + epi]
+		}
+	}
+	return 0
+}
+
+// zobriststate returns the part of the key that is not made of pieces
+func (b Board) zobriststate() uint64{
+	return zobristcastling(b.Castlefen) ^ b.zobristep() ^ zobristturn(b.Turnfen)
+}
+
+// Computezobrist computes the zobrist key of the board from scratch
+func (b Board) Computezobrist() uint64{
+	key := b.zobriststate()
+	for sq, p := range(b.Rep){
+		key ^= zobristpiece(sq, p)
+	}
+	return key
+}
+
+// setpiece puts a piece on a square updating the zobrist key
+func (b *Board) setpiece(sq int, p Piece){
+	b.Zobrist ^= zobristpiece(sq, b.Rep[sq]) ^ zobristpiece(sq, p)
+	b.Rep[sq] = p
+}
+
+////////////////////////////////////////////////////////////////
+
+func Fen2zobrist(fen string) (uint64, error){
+	b := NewBoard("")
+	err := b.Setfromfen(fen)
+	return b.Zobrist, err
+}
+
+func Zobristkeyhex(key uint64) string{
+	return fmt.Sprintf("%016x", key)
+}
+
+// Fen2zobristkeyhex returns the hex zobrist key of a fen
+func Fen2zobristkeyhex(fen string) (string, error){
+	key, err := Fen2zobrist(fen)
+	if err != nil{
+		return "", err
+	}
+	return Zobristkeyhex(key), nil
+}
+
+////////////////////////////////////////////////////////////////